@@ -37,6 +37,7 @@ which provides a (less set-theoretical) view of bitsets.
 package bitset
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"encoding/binary"
@@ -45,7 +46,11 @@ import (
 	"fmt"
 	"io"
 	"math/bits"
+	"math/rand"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
 )
 
 // the wordSize of a bit set
@@ -82,10 +87,38 @@ func BigEndian() { binaryOrder = binary.BigEndian }
 // and BigEndian() to change the order.
 func BinaryOrder() binary.ByteOrder { return binaryOrder }
 
+// defaultMaxDecodedLength is the default of SetMaxDecodedLength, 2^30 bits
+// (128 MiB of words).
+const defaultMaxDecodedLength = 1 << 30
+
+// maxDecodedLength is the largest length accepted by the decoders whose
+// input does not hold the words, see SetMaxDecodedLength
+var maxDecodedLength uint = defaultMaxDecodedLength
+
+// SetMaxDecodedLength sets the largest length in bits of a BitSet decoded
+// from a format whose size is not tied to the length, that is the delta
+// varint and JSON array formats, and ReadIndicesText (Default: 2^30 bits,
+// that is 128 MiB). A short input can
+// declare a huge length, and the runtime cannot recover from running out
+// of memory: such an input is rejected with an error instead.
+func SetMaxDecodedLength(length uint) { maxDecodedLength = length }
+
+// errDecodedLength returns the error for a decoded length beyond the limit
+// set by SetMaxDecodedLength.
+func errDecodedLength(length uint64) error {
+	return fmt.Errorf("unmarshalling error: length %d exceeds the limit of %d bits, see SetMaxDecodedLength", length, maxDecodedLength)
+}
+
 // A BitSet is a set of bits. The zero value of a BitSet is an empty set of length 0.
 type BitSet struct {
 	length uint
 	set    []uint64
+
+	jsonFormat JSONFormat
+	growth     GrowthPolicy
+
+	// shared is non-nil when the words may be shared with snapshots, see Snapshot
+	shared *sharedWords
 }
 
 // Error is used to distinguish errors (panics) generated in this package.
@@ -99,13 +132,47 @@ func (b *BitSet) safeSet() []uint64 {
 	return b.set
 }
 
-// SetBitsetFrom fills the bitset with an array of integers without creating a new BitSet instance
+// SetBitsetFrom fills the bitset with an array of integers without creating a new BitSet instance.
+// The slice is not copied: the BitSet takes ownership of it, see NewWithWords.
 func (b *BitSet) SetBitsetFrom(buf []uint64) {
+	b.release()
 	b.length = uint(len(buf)) * 64
 	b.set = buf
 }
 
-// From is a constructor used to create a BitSet from an array of words
+// NewWithWords creates a BitSet of the given length in bits that takes
+// ownership of the provided slice of words: the slice is not copied,
+// and it becomes the internal representation of the BitSet.
+// The caller must not reuse or modify the slice afterwards, since
+// changes would be reflected in the BitSet (and the BitSet may write to it).
+// The slice should have length at least (length+63)/64 and no bit at or
+// beyond length should be set. It panics if the slice is too short.
+//
+// From, FromWithLength and SetBitsetFrom follow the same ownership
+// semantics; use Clone on the result if you need an independent copy.
+func NewWithWords(length uint, words []uint64) *BitSet {
+	if len(words) < wordsNeeded(length) {
+		panic("BitSet.NewWithWords: slice is too short")
+	}
+	return &BitSet{length: length, set: words}
+}
+
+// SetBitsetFromWithLength fills the bitset with an array of integers and a
+// length in bits, without creating a new BitSet instance. It is the
+// counterpart of FromWithLength: you are responsible for ensuring that
+// the length is correct, and the slice should have length at least
+// (length+63)/64 in 64-bit words.
+func (b *BitSet) SetBitsetFromWithLength(length uint, buf []uint64) {
+	if len(buf) < wordsNeeded(length) {
+		panic("BitSet.SetBitsetFromWithLength: slice is too short")
+	}
+	b.release()
+	b.length = length
+	b.set = buf
+}
+
+// From is a constructor used to create a BitSet from an array of words.
+// The slice is not copied: the BitSet takes ownership of it, see NewWithWords.
 func From(buf []uint64) *BitSet {
 	return FromWithLength(uint(len(buf))*64, buf)
 }
@@ -116,11 +183,70 @@ func From(buf []uint64) *BitSet {
 // As a user of FromWithLength, you are responsible for ensuring
 // that the length is correct: your slice should have length at
 // least (length+63)/64 in 64-bit words.
+// The slice is not copied: the BitSet takes ownership of it, see NewWithWords.
 func FromWithLength(length uint, set []uint64) *BitSet {
 	if len(set) < wordsNeeded(length) {
 		panic("BitSet.FromWithLength: slice is too short")
 	}
-	return &BitSet{length, set}
+	return &BitSet{length: length, set: set}
+}
+
+// FromBytes is a constructor used to create a BitSet from a slice of bytes.
+// The bytes are interpreted as little-endian packed bits: bit j of data[k]
+// (with j=0 being the least significant bit) becomes bit 8*k+j of the BitSet.
+// The length of the BitSet is len(data)*8. The data is copied.
+//
+// See also [BitSet.ToBytes].
+func FromBytes(data []byte) *BitSet {
+	b := New(uint(len(data)) * 8)
+	for i, v := range data {
+		b.set[i>>3] |= uint64(v) << (uint(i&7) * 8)
+	}
+	return b
+}
+
+// ToBytes returns the content of the BitSet as a new slice of
+// little-endian packed bits, using (Len()+7)/8 bytes. It is the inverse
+// of [FromBytes]: bit i of the BitSet is stored as bit i%8 of byte i/8.
+func (b *BitSet) ToBytes() []byte {
+	data := make([]byte, (b.length+7)/8)
+	for i := range data {
+		data[i] = byte(b.set[i>>3] >> (uint(i&7) * 8))
+	}
+	return data
+}
+
+// ToUint32Slice appends the content of the BitSet to out as 32-bit words
+// and returns the (maybe extended) slice. Exactly (Len()+31)/32 words are
+// appended. Bit i of the BitSet is stored as bit i%32 of word i/32: in
+// other words, each 64-bit word is split into its low half followed by
+// its high half, independently of the endianness of the system.
+//
+// See also [FromUint32Slice].
+func (b *BitSet) ToUint32Slice(out []uint32) []uint32 {
+	n := int((b.length + 31) >> 5)
+	for i := 0; i < n; i++ {
+		out = append(out, uint32(b.set[i>>1]>>(uint(i&1)*32)))
+	}
+	return out
+}
+
+// FromUint32Slice is a constructor used to create a BitSet of the given length
+// in bits from 32-bit words, following the order of ToUint32Slice: bit i of
+// the BitSet is bit i%32 of data[i/32]. The data is copied. Bits of data at
+// or beyond length are ignored. It panics if data has fewer than
+// (length+31)/32 words.
+func FromUint32Slice(length uint, data []uint32) *BitSet {
+	n := int((length + 31) >> 5)
+	if len(data) < n {
+		panic("BitSet.FromUint32Slice: slice is too short")
+	}
+	b := New(length)
+	for i, v := range data[:n] {
+		b.set[i>>1] |= uint64(v) << (uint(i&1) * 32)
+	}
+	b.cleanLastWord()
+	return b
 }
 
 // Bytes returns the bitset as array of 64-bit words, giving direct access to the internal representation.
@@ -134,11 +260,58 @@ func (b *BitSet) Bytes() []uint64 {
 
 // Words returns the bitset as array of 64-bit words, giving direct access to the internal representation.
 // It is not a copy, so changes to the returned slice will affect the bitset.
-// It is meant for advanced users.
+// It is meant for advanced users. See [BitSet.WordsCopy] for a safe copy.
 func (b *BitSet) Words() []uint64 {
 	return b.set
 }
 
+// WordsCopy returns a copy of the words of the bitset needed to store
+// Len() bits. Unlike [BitSet.Words], the result does not give access
+// to the internal representation: changes to the returned slice
+// do not affect the bitset.
+func (b *BitSet) WordsCopy() []uint64 {
+	words := make([]uint64, len(b.usedWords()))
+	copy(words, b.set)
+	return words
+}
+
+// WordsRange returns a copy of the words of the bitset with indexes in
+// [wordStart, wordEnd), among the words needed to store Len() bits. The
+// indexes are clamped to [0, n], where n is the number of these words
+// (which may be less than WordCount()), so that the result is empty when
+// the range is empty. Like [BitSet.WordsCopy], the result does not
+// alias the bitset.
+func (b *BitSet) WordsRange(wordStart, wordEnd int) []uint64 {
+	words := b.usedWords()
+	if wordEnd > len(words) {
+		wordEnd = len(words)
+	}
+	if wordStart < 0 {
+		wordStart = 0
+	}
+	if wordStart >= wordEnd {
+		return []uint64{}
+	}
+	result := make([]uint64, wordEnd-wordStart)
+	copy(result, words[wordStart:wordEnd])
+	return result
+}
+
+// WordCount returns the number of 64-bit words in the backing slice,
+// that is len(b.Words()). It may exceed the number of words needed
+// to store Len() bits. It returns 0 for the zero value.
+func (b *BitSet) WordCount() int {
+	return len(b.set)
+}
+
+// Capacity returns the number of bits that the BitSet can hold without
+// reallocating its backing slice (a multiple of 64). It is at least Len()
+// but it can be larger, for example after the BitSet has grown.
+// Unlike the package-level Cap function, it is specific to the instance.
+func (b *BitSet) Capacity() uint {
+	return uint(cap(b.set)) * wordSize
+}
+
 // wordsNeeded calculates the number of words needed for i bits
 func wordsNeeded(i uint) int {
 	if i > (Cap() - wordMask) {
@@ -158,6 +331,22 @@ func wordsIndex(i uint) uint {
 	return i & wordMask
 }
 
+// BitToWordAndOffset maps the bit index i to its location in the slice
+// returned by Words: bit i is stored in Words()[word], as the bit with
+// value 1<<offset. Bits are numbered from the least significant bit of each
+// word, independently of the endianness of the system:
+// word is i/64 and offset is i%64.
+func BitToWordAndOffset(i uint) (word int, offset uint) {
+	return int(i >> log2WordSize), wordsIndex(i)
+}
+
+// WordAndOffsetToBit is the inverse of BitToWordAndOffset: it returns the
+// index of the bit stored in Words()[word] as the bit with value 1<<offset,
+// that is word*64+offset. The offset must be smaller than 64.
+func WordAndOffsetToBit(word int, offset uint) uint {
+	return uint(word)<<log2WordSize + offset
+}
+
 // New creates a new BitSet with a hint that length bits will be required.
 // The memory usage is at least length/8 bytes.
 // In case of allocation failure, the function will return a BitSet with zero
@@ -166,20 +355,42 @@ func New(length uint) (bset *BitSet) {
 	defer func() {
 		if r := recover(); r != nil {
 			bset = &BitSet{
-				0,
-				make([]uint64, 0),
+				length: 0,
+				set:    make([]uint64, 0),
 			}
 		}
 	}()
 
 	bset = &BitSet{
-		length,
-		make([]uint64, wordsNeeded(length)),
+		length: length,
+		set:    make([]uint64, wordsNeeded(length)),
 	}
 
 	return bset
 }
 
+// NewWithHint creates an empty BitSet (of length 0) with enough capacity
+// for the bits up to maxIndex, so that setting bits up to maxIndex does not
+// allocate. The expected number of set bits is a hint for the choice of
+// the internal representation: for now, the BitSet is always dense, and
+// expectedCount is ignored, but it may be used by future versions.
+// In case of allocation failure, the function will return a BitSet with
+// zero capacity.
+func NewWithHint(maxIndex uint, expectedCount uint) (bset *BitSet) {
+	defer func() {
+		if r := recover(); r != nil {
+			bset = &BitSet{
+				length: 0,
+				set:    make([]uint64, 0),
+			}
+		}
+	}()
+
+	return &BitSet{
+		set: make([]uint64, 0, int(maxIndex>>log2WordSize)+1),
+	}
+}
+
 // MustNew creates a new BitSet with the given length bits.
 // It panics if length exceeds the possible capacity or by a lack of memory.
 func MustNew(length uint) (bset *BitSet) {
@@ -188,9 +399,56 @@ func MustNew(length uint) (bset *BitSet) {
 	}
 
 	return &BitSet{
-		length,
-		make([]uint64, wordsNeeded(length)), // may panic on lack of memory
+		length: length,
+		set:    make([]uint64, wordsNeeded(length)), // may panic on lack of memory
+	}
+}
+
+// MergeSorted builds a BitSet holding the union of several slices of indices,
+// such as posting lists. Each slice must be sorted in increasing order: the
+// largest index of each slice is taken to be its last element, so that the
+// BitSet is sized once. Duplicates, within or across slices, are allowed.
+// The length of the result is the largest index plus one. MergeSorted panics
+// if a slice is not sorted.
+func MergeSorted(streams ...[]uint) *BitSet {
+	var length uint
+	for _, s := range streams {
+		for k := 1; k < len(s); k++ {
+			if s[k] < s[k-1] {
+				panic(Error("MergeSorted: the indices must be sorted in increasing order"))
+			}
+		}
+		if len(s) > 0 && s[len(s)-1] >= length {
+			if s[len(s)-1] == Cap() {
+				panic("You are exceeding the capacity")
+			}
+			length = s[len(s)-1] + 1
+		}
+	}
+	b := New(length)
+	for _, s := range streams {
+		for _, i := range s {
+			b.set[i>>log2WordSize] |= 1 << wordsIndex(i)
+		}
 	}
+	return b
+}
+
+// FromChan builds a BitSet from the indices received on ch, growing it as
+// needed. It blocks until ch is closed. Duplicate indices are allowed.
+// The length of the result is the largest index plus one, and the excess
+// capacity left by the successive growths is released before returning.
+func FromChan(ch <-chan uint) *BitSet {
+	b := New(0)
+	for i := range ch {
+		b.Set(i)
+	}
+	if len(b.set) < cap(b.set) {
+		set := make([]uint64, len(b.set))
+		copy(set, b.set)
+		b.set = set
+	}
+	return b
 }
 
 // Cap returns the total possible capacity, or number of bits
@@ -219,14 +477,67 @@ func (b *BitSet) extendSet(i uint) {
 	} else if cap(b.set) >= nsize {
 		b.set = b.set[:nsize] // fast resize
 	} else if len(b.set) < nsize {
-		newset := make([]uint64, nsize, 2*nsize) // increase capacity 2x
+		newset := make([]uint64, nsize, b.growth.capacity(nsize))
 		copy(newset, b.set)
 		b.set = newset
 	}
 	b.length = i + 1
 }
 
+// tryExtendSet is like extendSet, but it returns an error instead of
+// panicking when i exceeds the capacity or when the number of words is out
+// of the range of a slice. It cannot catch the fatal error of the runtime
+// when the memory is exhausted: the indexes read from untrusted input must
+// also be bounded, see SetMaxDecodedLength.
+func (b *BitSet) tryExtendSet(i uint) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot grow the BitSet to hold the bit %d: %v", i, r)
+		}
+	}()
+	b.extendSet(i)
+	return nil
+}
+
+// GrowthPolicy selects the capacity allocated when a BitSet needs to grow
+// beyond the capacity of its backing slice, see SetGrowthPolicy. A larger
+// capacity makes reallocations less frequent, at the cost of a higher
+// peak memory usage.
+type GrowthPolicy int
+
+const (
+	// GrowthDouble allocates twice the number of words needed. It is the default.
+	GrowthDouble GrowthPolicy = iota
+	// GrowthHalf allocates 1.5 times the number of words needed.
+	GrowthHalf
+	// GrowthExact allocates exactly the number of words needed: there is no
+	// excess capacity, but each growth copies the whole backing slice.
+	GrowthExact
+)
+
+// capacity returns the capacity in words to allocate for nsize words
+func (p GrowthPolicy) capacity(nsize int) int {
+	switch p {
+	case GrowthHalf:
+		return nsize + nsize/2
+	case GrowthExact:
+		return nsize
+	default:
+		return 2 * nsize // increase capacity 2x
+	}
+}
+
+// SetGrowthPolicy selects how this instance grows its backing slice, and
+// returns the BitSet. The default is GrowthDouble. The BitSets derived from
+// this instance, such as its clones, snapshots and the results of the set
+// operations, have the same policy.
+func (b *BitSet) SetGrowthPolicy(policy GrowthPolicy) *BitSet {
+	b.growth = policy
+	return b
+}
+
 // Test whether bit i is set.
+// It panics if the BitSet is nil, see SafeTest for a nil-safe alternative.
 func (b *BitSet) Test(i uint) bool {
 	if i >= b.length {
 		return false
@@ -234,6 +545,37 @@ func (b *BitSet) Test(i uint) bool {
 	return b.set[i>>log2WordSize]&(1<<wordsIndex(i)) != 0
 }
 
+// TestMany tests several bits at once: after the call, bit j of out is set
+// if and only if Test(indices[j]) is true, and the length of out is
+// len(indices). Indices beyond the length of the BitSet give clear bits.
+// The BitSet out is cleared and reused; if it is nil, or if it is the
+// BitSet itself, a new BitSet is allocated. The function returns the
+// BitSet holding the results.
+func (b *BitSet) TestMany(indices []uint, out *BitSet) *BitSet {
+	if out == nil || out == b {
+		out = New(uint(len(indices)))
+	} else {
+		out.resizeTo(uint(len(indices)))
+		out.ClearAll()
+	}
+	for j, i := range indices {
+		if b.Test(i) {
+			out.set[j>>log2WordSize] |= 1 << wordsIndex(uint(j))
+		}
+	}
+	return out
+}
+
+// SafeTest whether bit i is set. Unlike Test, it does not panic if the
+// BitSet is nil: a nil BitSet is treated as an empty set and SafeTest returns
+// false. It is convenient when handling optional BitSets.
+func (b *BitSet) SafeTest(i uint) bool {
+	if b == nil {
+		return false
+	}
+	return b.Test(i)
+}
+
 // GetWord64AtBit retrieves bits i through i+63 as a single uint64 value
 func (b *BitSet) GetWord64AtBit(i uint) uint64 {
 	firstWordIndex := int(i >> log2WordSize)
@@ -254,6 +596,20 @@ func (b *BitSet) GetWord64AtBit(i uint) uint64 {
 	return firstWord | secondWord
 }
 
+// putBits writes the low width bits of v (1 <= width <= 64) at the bits i
+// through i+width-1, which must be within the backing slice, possibly
+// straddling two words. The other bits of v must be 0.
+func (b *BitSet) putBits(i uint, v uint64, width uint) {
+	mask := allBits >> (wordSize - width)
+	x, shift := int(i>>log2WordSize), wordsIndex(i)
+	b.set[x] = b.set[x]&^(mask<<shift) | v<<shift
+	if shift+width > wordSize {
+		// the high bits go to the next word
+		rshift := wordSize - shift
+		b.set[x+1] = b.set[x+1]&^(mask>>rshift) | v>>rshift
+	}
+}
+
 // Set bit i to 1, the capacity of the bitset is automatically
 // increased accordingly.
 // Warning: using a very large value for 'i'
@@ -261,6 +617,7 @@ func (b *BitSet) GetWord64AtBit(i uint) uint64 {
 // for providing sensible parameters in line with their memory capacity.
 // The memory usage is at least slightly over i/8 bytes.
 func (b *BitSet) Set(i uint) *BitSet {
+	b.prepareWrite()
 	if i >= b.length { // if we need more bits, make 'em
 		b.extendSet(i)
 	}
@@ -268,8 +625,72 @@ func (b *BitSet) Set(i uint) *BitSet {
 	return b
 }
 
+// SetMaskAt sets bit offset+k for each bit k set in mask, growing the
+// BitSet as needed, and returns the BitSet. It is convenient to expand a
+// small pattern of relative positions into a large set: it ORs mask,
+// shifted by offset, into at most two words. A zero mask does nothing.
+func (b *BitSet) SetMaskAt(offset uint, mask uint64) *BitSet {
+	if mask == 0 {
+		return b
+	}
+	last := uint(bits.Len64(mask)) - 1 // highest set bit of mask
+	if last >= Cap()-offset {
+		panic("You are exceeding the capacity")
+	}
+	b.prepareWrite()
+	if offset+last >= b.length {
+		b.extendSet(offset + last)
+	}
+	x, shift := int(offset>>log2WordSize), wordsIndex(offset)
+	b.set[x] |= mask << shift
+	if high := mask >> (wordSize - shift); shift != 0 && high != 0 {
+		b.set[x+1] |= high
+	}
+	return b
+}
+
+// ErrOutOfRange is returned (possibly wrapped) by the strict methods,
+// such as SetStrict, when an index is not within the length of the BitSet.
+var ErrOutOfRange = errors.New("bitset: index out of range")
+
+// SetStrict sets bit i to 1 if i is within the length of the BitSet.
+// Unlike Set, it never grows the BitSet: it returns an error wrapping
+// ErrOutOfRange when i >= Len(). This is useful to catch out-of-domain
+// writes when the BitSet represents a fixed domain.
+func (b *BitSet) SetStrict(i uint) error {
+	b.prepareWrite()
+	if i >= b.length {
+		return fmt.Errorf("%w: %d >= %d", ErrOutOfRange, i, b.length)
+	}
+	b.set[i>>log2WordSize] |= 1 << wordsIndex(i)
+	return nil
+}
+
+// TestInt is like Test, for an index computed as an int:
+// it returns false when i is negative, instead of converting it to
+// a huge unsigned index.
+func (b *BitSet) TestInt(i int) bool {
+	if i < 0 {
+		return false
+	}
+	return b.Test(uint(i))
+}
+
+// SetInt is like Set, for an index computed as an int: it returns
+// an error wrapping ErrOutOfRange when i is negative, instead of
+// converting it to a huge unsigned index and trying to grow the
+// BitSet accordingly.
+func (b *BitSet) SetInt(i int) error {
+	if i < 0 {
+		return fmt.Errorf("%w: %d < 0", ErrOutOfRange, i)
+	}
+	b.Set(uint(i))
+	return nil
+}
+
 // Clear bit i to 0. This never cause a memory allocation. It is always safe.
 func (b *BitSet) Clear(i uint) *BitSet {
+	b.prepareWrite()
 	if i >= b.length {
 		return b
 	}
@@ -277,6 +698,177 @@ func (b *BitSet) Clear(i uint) *BitSet {
 	return b
 }
 
+// ClearFrom clears all bits at or above index i, leaving the
+// length of the BitSet unchanged. It never causes a memory allocation.
+func (b *BitSet) ClearFrom(i uint) *BitSet {
+	b.prepareWrite()
+	x := int(i >> log2WordSize)
+	if x >= len(b.set) {
+		return b
+	}
+	// mask off the boundary word, then zero the following words
+	b.set[x] &= (1 << wordsIndex(i)) - 1
+	for k := x + 1; k < len(b.set); k++ {
+		b.set[k] = 0
+	}
+	return b
+}
+
+// ClearUpTo clears all bits strictly below index i, leaving the
+// length of the BitSet unchanged. It never causes a memory allocation.
+func (b *BitSet) ClearUpTo(i uint) *BitSet {
+	b.prepareWrite()
+	x := int(i >> log2WordSize)
+	if x >= len(b.set) {
+		return b.ClearAll()
+	}
+	// zero the whole words, then mask off the boundary word
+	for k := 0; k < x; k++ {
+		b.set[k] = 0
+	}
+	b.set[x] &^= (1 << wordsIndex(i)) - 1
+	return b
+}
+
+// ClearRange clears the bits in [start, end). Bits beyond the length of
+// the BitSet are already clear: it never causes a memory allocation and
+// it does not change the length.
+func (b *BitSet) ClearRange(start, end uint) *BitSet {
+	b.prepareWrite()
+	if end > b.length {
+		end = b.length
+	}
+	if start >= end {
+		return b
+	}
+	startWord := int(start >> log2WordSize)
+	endWord := int(end >> log2WordSize)
+	startMask := allBits << wordsIndex(start)     // bits >= start in the first word
+	endMask := (uint64(1) << wordsIndex(end)) - 1 // bits < end in the last word
+	if startWord == endWord {
+		b.set[startWord] &^= startMask & endMask
+		return b
+	}
+	b.set[startWord] &^= startMask
+	for i := startWord + 1; i < endWord; i++ {
+		b.set[i] = 0
+	}
+	if endMask != 0 {
+		b.set[endWord] &^= endMask
+	}
+	return b
+}
+
+// SetFirst sets the first n bits and sets the length of the BitSet to
+// exactly n: the BitSet grows if it is shorter, and the bits at or beyond n
+// are dropped if it is longer. After the call, Count() is n. It returns
+// the BitSet.
+func (b *BitSet) SetFirst(n uint) *BitSet {
+	b.resizeTo(n)
+	return b.SetAll()
+}
+
+// SetLast sets the last n bits below the length of the BitSet, that is,
+// the bits in [Len()-n, Len()), or all the bits when n >= Len(). It does
+// not change the length. It returns the BitSet.
+func (b *BitSet) SetLast(n uint) *BitSet {
+	b.prepareWrite()
+	if n > b.length {
+		n = b.length
+	}
+	if n == 0 {
+		return b
+	}
+	start, end := b.length-n, b.length
+	startWord := int(start >> log2WordSize)
+	endWord := int(end >> log2WordSize)
+	startMask := allBits << wordsIndex(start)     // bits >= start in the first word
+	endMask := (uint64(1) << wordsIndex(end)) - 1 // bits < end in the last word
+	if startWord == endWord {
+		b.set[startWord] |= startMask & endMask
+		return b
+	}
+	b.set[startWord] |= startMask
+	for i := startWord + 1; i < endWord; i++ {
+		b.set[i] = allBits
+	}
+	if endMask != 0 {
+		b.set[endWord] |= endMask
+	}
+	return b
+}
+
+// ClearLast clears the last n bits below the length of the BitSet, that is,
+// the bits in [Len()-n, Len()), or all the bits when n >= Len(). It does
+// not change the length. It returns the BitSet.
+func (b *BitSet) ClearLast(n uint) *BitSet {
+	if n > b.length {
+		n = b.length
+	}
+	return b.ClearRange(b.length-n, b.length)
+}
+
+// WithoutRange returns a copy of the BitSet with the bits in [start, end)
+// cleared. The BitSet itself is not modified. See ClearRange.
+func (b *BitSet) WithoutRange(start, end uint) *BitSet {
+	return b.Clone().ClearRange(start, end)
+}
+
+// IntersectRange returns a copy of the BitSet holding only the set bits
+// in [start, end): all the other bits are cleared, and the length is
+// unchanged. It is the intersection with the range [start, end), computed
+// with masks on the boundary words, without building a mask BitSet.
+// The BitSet itself is not modified.
+func (b *BitSet) IntersectRange(start, end uint) *BitSet {
+	panicIfNull(b)
+	result := New(b.length)
+	if end > b.length {
+		end = b.length
+	}
+	if start >= end {
+		return result
+	}
+	startWord := int(start >> log2WordSize)
+	endWord := int(end >> log2WordSize)
+	copy(result.set[startWord:endWord], b.set[startWord:endWord])
+	if endMask := (uint64(1) << wordsIndex(end)) - 1; endMask != 0 { // bits < end in the last word
+		result.set[endWord] = b.set[endWord] & endMask
+	}
+	result.set[startWord] &= allBits << wordsIndex(start) // bits >= start in the first word
+	return result
+}
+
+// ContainsRange returns true if every bit in [start, end) is set. It
+// returns false when end is beyond Len(), since these bits cannot be set,
+// and true when the range is empty (start >= end) and within Len().
+// The full words of the range are compared to all ones.
+func (b *BitSet) ContainsRange(start, end uint) bool {
+	if end > b.length {
+		return false
+	}
+	if start >= end {
+		return true
+	}
+	startWord := int(start >> log2WordSize)
+	endWord := int(end >> log2WordSize)
+	startMask := allBits << wordsIndex(start)     // bits >= start in the first word
+	endMask := (uint64(1) << wordsIndex(end)) - 1 // bits < end in the last word
+	if startWord == endWord {
+		mask := startMask & endMask
+		return b.set[startWord]&mask == mask
+	}
+	if b.set[startWord]&startMask != startMask {
+		return false
+	}
+	for _, w := range b.set[startWord+1 : endWord] {
+		if w != allBits {
+			return false
+		}
+	}
+	// endMask is 0 when end is a multiple of 64, and endWord may then be out of range
+	return endMask == 0 || b.set[endWord]&endMask == endMask
+}
+
 // SetTo sets bit i to value.
 // Warning: using a very large value for 'i'
 // may lead to a memory shortage and a panic: the caller is responsible
@@ -288,11 +880,22 @@ func (b *BitSet) SetTo(i uint, value bool) *BitSet {
 	return b.Clear(i)
 }
 
+// SetIf sets bit i when cond is true, and does nothing otherwise: unlike
+// SetTo, it never clears a bit, and the BitSet does not grow when cond
+// is false, however large i may be.
+func (b *BitSet) SetIf(i uint, cond bool) *BitSet {
+	if cond {
+		return b.Set(i)
+	}
+	return b
+}
+
 // Flip bit at i.
 // Warning: using a very large value for 'i'
 // may lead to a memory shortage and a panic: the caller is responsible
 // for providing sensible parameters in line with their memory capacity.
 func (b *BitSet) Flip(i uint) *BitSet {
+	b.prepareWrite()
 	if i >= b.length {
 		return b.Set(i)
 	}
@@ -305,6 +908,7 @@ func (b *BitSet) Flip(i uint) *BitSet {
 // may lead to a memory shortage and a panic: the caller is responsible
 // for providing sensible parameters in line with their memory capacity.
 func (b *BitSet) FlipRange(start, end uint) *BitSet {
+	b.prepareWrite()
 	if start >= end {
 		return b
 	}
@@ -359,6 +963,26 @@ func (b *BitSet) FlipRange(start, end uint) *BitSet {
 	return b
 }
 
+// SetWhere sets each bit i in [start, end) for which pred(i) is true, and
+// returns the BitSet. If end is beyond the length, the BitSet is first
+// extended to a length of end, in a single allocation, even if pred never
+// returns true. The function calls pred once per bit, in increasing order.
+func (b *BitSet) SetWhere(start, end uint, pred func(i uint) bool) *BitSet {
+	if start >= end {
+		return b
+	}
+	b.prepareWrite()
+	if end > b.length {
+		b.extendSet(end - 1)
+	}
+	for i := start; i < end; i++ {
+		if pred(i) {
+			b.set[i>>log2WordSize] |= 1 << wordsIndex(i)
+		}
+	}
+	return b
+}
+
 // Shrink shrinks BitSet so that the provided value is the last possible
 // set value. It clears all bits > the provided index and reduces the size
 // and length of the set.
@@ -375,6 +999,7 @@ func (b *BitSet) FlipRange(start, end uint) *BitSet {
 // remain in memory until the GC frees it.
 // If you are memory constrained, this function may cause a panic.
 func (b *BitSet) Shrink(lastbitindex uint) *BitSet {
+	b.prepareWrite()
 	length := lastbitindex + 1
 	idx := wordsNeeded(length)
 	if idx > len(b.set) {
@@ -413,6 +1038,15 @@ func (b *BitSet) Compact() *BitSet {
 	return b.Shrink(63)
 }
 
+// CompactReport is like Compact, but it returns the number of words
+// dropped from the backing slice, which is 0 when Compact has nothing to do.
+// This is useful to measure the memory reclaimed after bulk deletions.
+func (b *BitSet) CompactReport() (freedWords int) {
+	before := len(b.set)
+	b.Compact()
+	return before - len(b.set)
+}
+
 // InsertAt takes an index which indicates where a bit should be
 // inserted. Then it shifts all the bits in the set to the left by 1, starting
 // from the given index position, and sets the index position to 0.
@@ -421,6 +1055,7 @@ func (b *BitSet) Compact() *BitSet {
 // this method could be extremely slow and in some cases might cause the entire BitSet
 // to be recopied.
 func (b *BitSet) InsertAt(idx uint) *BitSet {
+	b.prepareWrite()
 	insertAtElement := idx >> log2WordSize
 
 	// if length of set is a multiple of wordSize we need to allocate more space first
@@ -483,6 +1118,101 @@ func (b *BitSet) String() string {
 	return buffer.String()
 }
 
+// Format implements fmt.Formatter. The %b verb prints the bits, for
+// flexible human-readable output:
+//
+//	%b      the words as in DumpAsBits
+//	%+b     verbose: DumpAsBits followed by the length and the count
+//	%#b     compact: the Len() bits, most significant first, without separators
+//
+// The precision, if any, caps the output of %b: longer outputs are truncated
+// and end with "...", within that many characters. The width pads the output
+// with spaces, on the left or, with the '-' flag, on the right.
+// The other verbs are formatted as for any Stringer: %v, %s, %q, %x and %X
+// format String(), and %#v prints the BitSet in Go syntax.
+// Like String and DumpAsBits, it is not suitable for serialization.
+func (b *BitSet) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'b':
+		b.formatBits(f)
+	case 'v', 's', 'q', 'x', 'X':
+		if verb == 'v' && f.Flag('#') {
+			// the fields in Go syntax, under the name of the BitSet type
+			s := fmt.Sprintf(formatDirective(f, verb), (*bitSetFields)(b))
+			io.WriteString(f, strings.Replace(s, "bitset.bitSetFields", "bitset.BitSet", 1))
+			return
+		}
+		fmt.Fprintf(f, formatDirective(f, verb), b.String())
+	default:
+		fmt.Fprintf(f, formatDirective(f, verb), (*bitSetFields)(b))
+	}
+}
+
+// bitSetFields is a BitSet without its methods, to format its fields like
+// fmt does for the types that are neither Formatters nor Stringers.
+type bitSetFields BitSet
+
+// formatDirective returns the directive, such as %-10.5q, for which f and
+// verb were passed to Format.
+func formatDirective(f fmt.State, verb rune) string {
+	directive := "%"
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			directive += string(flag)
+		}
+	}
+	if width, ok := f.Width(); ok {
+		directive += strconv.Itoa(width)
+	}
+	if prec, ok := f.Precision(); ok {
+		directive += "." + strconv.Itoa(prec)
+	}
+	return directive + string(verb)
+}
+
+// formatBits formats the bits of b for the %b verb, see Format.
+func (b *BitSet) formatBits(f fmt.State) {
+	var s string
+	if f.Flag('#') {
+		s = b.bitString()
+	} else {
+		s = b.DumpAsBits()
+	}
+	if f.Flag('+') {
+		s += fmt.Sprintf(" (len=%d, count=%d)", b.length, b.Count())
+	}
+	if prec, ok := f.Precision(); ok && len(s) > prec {
+		if prec > len("...") {
+			s = s[:prec-len("...")] + "..."
+		} else {
+			s = s[:prec]
+		}
+	}
+	pad := ""
+	if width, ok := f.Width(); ok && width > len(s) {
+		pad = strings.Repeat(" ", width-len(s))
+	}
+	if f.Flag('-') {
+		s += pad
+	} else {
+		s = pad + s
+	}
+	io.WriteString(f, s)
+}
+
+// bitString returns the Len() bits of the BitSet, most significant first.
+func (b *BitSet) bitString() string {
+	s := make([]byte, b.length)
+	for i := uint(0); i < b.length; i++ {
+		if b.Test(i) {
+			s[b.length-1-i] = '1'
+		} else {
+			s[b.length-1-i] = '0'
+		}
+	}
+	return string(s)
+}
+
 // DeleteAt deletes the bit at the given index position from
 // within the bitset
 // All the bits residing on the left of the deleted bit get
@@ -490,6 +1220,7 @@ func (b *BitSet) String() string {
 // The running time of this operation may potentially be
 // relatively slow, O(length)
 func (b *BitSet) DeleteAt(i uint) *BitSet {
+	b.prepareWrite()
 	// the index of the slice element where we'll delete a bit
 	deleteAtElement := i >> log2WordSize
 
@@ -595,6 +1326,84 @@ func (b *BitSet) NextSet(i uint) (uint, bool) {
 	return 0, false
 }
 
+// NextSetCyclic returns the next set bit from the specified index,
+// including possibly the current index, wrapping around to index 0 when
+// there is no set bit at or after start: a round-robin scheduler can thus
+// resume from the last position and cycle through the set bits.
+// It returns false only when the BitSet has no set bit.
+func (b *BitSet) NextSetCyclic(start uint) (uint, bool) {
+	if i, ok := b.NextSet(start); ok {
+		return i, true
+	}
+	return b.NextSet(0)
+}
+
+// Iterator iterates over the set bits of a BitSet, in increasing order.
+// Unlike a loop over [BitSet.NextSet], it caches the current word so that
+// sequential iteration does not need to locate the word again at each step.
+// An Iterator is obtained with [BitSet.Iterator]; it does not allocate.
+//
+//	it := b.Iterator()
+//	for i, ok := it.Next(); ok; i, ok = it.Next() {
+//	 do something with i
+//	}
+//
+// The BitSet should not be modified while it is being iterated.
+type Iterator struct {
+	set  []uint64
+	word uint64 // remaining bits of the current word
+	base uint   // index of the first bit of the current word
+	next int    // index of the next word to load
+}
+
+// Iterator returns an Iterator positioned before the first set bit.
+func (b *BitSet) Iterator() Iterator {
+	return Iterator{set: b.set}
+}
+
+// Next returns the next set bit along with true, or 0 and false
+// when no set bit remains.
+func (it *Iterator) Next() (uint, bool) {
+	for it.word == 0 {
+		if it.next >= len(it.set) {
+			return 0, false
+		}
+		it.word = it.set[it.next]
+		it.base = uint(it.next) << log2WordSize
+		it.next++
+	}
+	t := uint(bits.TrailingZeros64(it.word))
+	// clear the rightmost set bit
+	it.word &= it.word - 1
+	return it.base + t, true
+}
+
+// NextSetN returns the index of the kth set bit at or after index start,
+// where k=0 designates the first one, so that NextSetN(i, 0) is NextSet(i)
+// and NextSetN(0, k) is Select(k). It returns false when there are k or
+// fewer set bits at or after start. This is useful to page through results.
+func (b *BitSet) NextSetN(start, k uint) (uint, bool) {
+	x := int(start >> log2WordSize)
+	if x >= len(b.set) {
+		return 0, false
+	}
+
+	// process first (partial) word
+	word := b.set[x] &^ ((1 << wordsIndex(start)) - 1)
+	for {
+		w := uint(bits.OnesCount64(word))
+		if w > k {
+			return uint(x)<<log2WordSize + select64(word, k), true
+		}
+		k -= w
+		x++
+		if x >= len(b.set) {
+			return 0, false
+		}
+		word = b.set[x]
+	}
+}
+
 // NextSetMany returns many next bit sets from the specified index,
 // including possibly the current index and up to cap(buffer).
 // If the returned slice has len zero, then no more set bits were found
@@ -727,6 +1536,34 @@ func (b *BitSet) PreviousSet(i uint) (uint, bool) {
 	return 0, false
 }
 
+// NearestSet returns the set bit closest to index i, that is, with the
+// minimal |index - i|, along with true, or false when no bit is set.
+// When two set bits are at the same distance, the lower index is returned.
+// The index i may be beyond Len().
+func (b *BitSet) NearestSet(i uint) (uint, bool) {
+	next, nextOk := b.NextSet(i)
+	if nextOk && next == i {
+		return i, true
+	}
+	var prev uint
+	var prevOk bool
+	if i >= b.length {
+		prev, prevOk = b.top()
+	} else {
+		prev, prevOk = b.PreviousSet(i)
+	}
+	switch {
+	case !nextOk:
+		return prev, prevOk
+	case !prevOk:
+		return next, true
+	case i-prev <= next-i:
+		return prev, true
+	default:
+		return next, true
+	}
+}
+
 // PreviousClear returns the previous clear bit from the specified index,
 // including possibly the current index
 // along with an error code (true = valid, false = no clear bit found i.e. all bits are set)
@@ -757,20 +1594,87 @@ func (b *BitSet) PreviousClear(i uint) (uint, bool) {
 	return 0, false
 }
 
+// AllocClearN finds the n lowest clear bits, sets them, and appends their
+// indexes to out, in increasing order. When there are fewer than n clear
+// bits below Len(), the BitSet is extended to provide the missing bits.
+// This allocates n slots at once when the BitSet tracks the used slots of
+// a pool. It returns the resulting slice.
+func (b *BitSet) AllocClearN(n uint, out []uint) []uint {
+	panicIfNull(b)
+	if n == 0 {
+		return out
+	}
+	b.prepareWrite()
+	for i := uint(0); n > 0; n-- {
+		j, ok := b.NextClear(i)
+		if !ok {
+			break
+		}
+		b.set[j>>log2WordSize] |= 1 << wordsIndex(j)
+		out = append(out, j)
+		i = j + 1
+	}
+	if n > 0 {
+		start := b.length
+		if n > Cap()-start {
+			panic("You are exceeding the capacity")
+		}
+		b.extendSet(start + n - 1)
+		for j := start; j < b.length; j++ {
+			b.set[j>>log2WordSize] |= 1 << wordsIndex(j)
+			out = append(out, j)
+		}
+	}
+	return out
+}
+
 // ClearAll clears the entire BitSet.
-// It does not free the memory.
+// It does not free the memory, and it does not change Len():
+// use Reset to also set the length to 0.
 func (b *BitSet) ClearAll() *BitSet {
 	if b != nil && b.set != nil {
-		for i := range b.set {
-			b.set[i] = 0
-		}
+		b.prepareWrite()
+		clearWords(b.set)
+	}
+	return b
+}
+
+// Reset empties the BitSet, setting its length to 0 and clearing all
+// bits, but it does not free the memory: the backing array is kept
+// so that the BitSet can be reused without allocating (unless the array
+// is shared with a snapshot, see Snapshot, in which case it is given up).
+// After Reset, the BitSet behaves like New(0): its options, such as its
+// JSON format and growth policy, are also reset to their defaults.
+// See also [Pool].
+func (b *BitSet) Reset() *BitSet {
+	if b != nil {
+		b.empty()
+		b.jsonFormat = JSONBase64
+		b.growth = GrowthDouble
 	}
 	return b
 }
 
+// empty sets the length of b to 0 and clears its words, keeping its
+// backing array and its options. Shared words are given up rather than
+// copied (see Snapshot).
+func (b *BitSet) empty() {
+	if b.shared != nil {
+		b.release()
+		b.set = nil
+	} else if b.set != nil {
+		// we clear the whole capacity since extendSet may reslice
+		// into it later
+		clearWords(b.set[:cap(b.set)])
+		b.set = b.set[:0]
+	}
+	b.length = 0
+}
+
 // SetAll sets the entire BitSet
 func (b *BitSet) SetAll() *BitSet {
 	if b != nil && b.set != nil {
+		b.prepareWrite()
 		for i := range b.set {
 			b.set[i] = allBits
 		}
@@ -785,26 +1689,168 @@ func (b *BitSet) wordCount() int {
 	return wordsNeededUnbound(b.length)
 }
 
+// SignificantWords returns a copy of the words of the bitset up to, and
+// including, the highest non-zero word. It returns an empty slice when no
+// bit is set. The result only depends on the set bits, not on the length
+// or on the capacity of the bitset, which makes it suitable to build
+// stable hashes or keys.
+func (b *BitSet) SignificantWords() []uint64 {
+	words := b.usedWords()
+	n := len(words)
+	for ; n > 0 && words[n-1] == 0; n-- {
+	}
+	result := make([]uint64, n)
+	copy(result, words)
+	return result
+}
+
+// usedWords returns the words of the backing slice that are needed to
+// store Len() bits. The backing slice may be longer (see FromWithLength).
+func (b *BitSet) usedWords() []uint64 {
+	n := b.wordCount()
+	if n > len(b.set) {
+		n = len(b.set)
+	}
+	return b.set[:n]
+}
+
 // Clone this BitSet, returning a new BitSet that has the same bits set.
+// The clone has the same options, such as its JSON format and growth policy.
 // In case of allocation failure, the function will return an empty BitSet.
 func (b *BitSet) Clone() *BitSet {
 	c := New(b.length)
 	if b.set != nil { // Clone should not modify current object
 		copy(c.set, b.set)
 	}
+	return b.copyOptions(c)
+}
+
+// copyOptions sets the per-instance options of c, its JSON format (see
+// SetJSONFormat) and growth policy (see SetGrowthPolicy), to those of b,
+// and returns c. It is used for the
+// BitSets derived from b, such as its clones and the results of the set
+// operations with b as the receiver.
+func (b *BitSet) copyOptions(c *BitSet) *BitSet {
+	c.jsonFormat = b.jsonFormat
+	c.growth = b.growth
 	return c
 }
 
+// CompactCopy returns a copy of the BitSet holding only its significant
+// words (see SignificantWords), in a backing slice of exactly that size: it
+// is like Clone followed by Compact, without the intermediate copy. The
+// copy has the same set bits, but its length is the smaller of Len() and
+// the number of bits in the significant words: it is Len() when the last
+// word holds a set bit, and 0 when no bit is set. Thus, unlike a clone, the
+// copy is not Equal to the BitSet in general. This is convenient to store
+// many small BitSets, such as in a cache.
+func (b *BitSet) CompactCopy() *BitSet {
+	panicIfNull(b)
+	set := b.SignificantWords()
+	length := uint(len(set)) << log2WordSize
+	if b.length < length {
+		length = b.length
+	}
+	return b.copyOptions(&BitSet{length: length, set: set})
+}
+
+// sharedWords counts the BitSets sharing a backing array after Snapshot.
+type sharedWords struct {
+	refs int32
+}
+
+// Snapshot returns a BitSet with the same length and bits as b, which
+// shares the backing array of b until either of them is modified: the
+// first modification of the original or of a snapshot copies the words
+// (copy-on-write), and the last BitSet still referring to the array
+// takes it over without a copy. Thus taking a snapshot is cheap when
+// most snapshots are never modified.
+//
+// Snapshot modifies b (it marks its words as shared), so it must not be
+// called concurrently with other methods of b. Once taken, a snapshot and
+// the original are independent BitSets: each of them may be used (and
+// modified) from its own goroutine, as long as each BitSet is used by one
+// goroutine at a time. The words are only shared by the methods of BitSet:
+// writing through the slice returned by Words (or Bytes), or through a
+// BitSet built on it with From, bypasses the copy-on-write and affects all
+// the BitSets sharing the array.
+func (b *BitSet) Snapshot() *BitSet {
+	panicIfNull(b)
+	if b.shared == nil {
+		b.shared = &sharedWords{refs: 1}
+	}
+	atomic.AddInt32(&b.shared.refs, 1)
+	return b.copyOptions(&BitSet{length: b.length, set: b.set, shared: b.shared})
+}
+
+// SharesBackingWith reports whether b and other use overlapping parts of
+// the same backing array, so that modifying the words of one of them may
+// modify the other. This is a diagnostic aid for aliasing bugs with the
+// APIs that do not copy the words, such as From, Words and Snapshot (note
+// that a Snapshot stops sharing its words once either BitSet is modified).
+func (b *BitSet) SharesBackingWith(other *BitSet) bool {
+	panicIfNull(b)
+	panicIfNull(other)
+	if cap(b.set) == 0 || cap(other.set) == 0 {
+		return false
+	}
+	start1 := uintptr(unsafe.Pointer(&b.set[:1][0]))
+	end1 := start1 + uintptr(cap(b.set))*wordBytes
+	start2 := uintptr(unsafe.Pointer(&other.set[:1][0]))
+	end2 := start2 + uintptr(cap(other.set))*wordBytes
+	return start1 < end2 && start2 < end1
+}
+
+// prepareWrite must be called before the words of b are modified: it makes
+// sure that b owns its words (see Snapshot).
+func (b *BitSet) prepareWrite() {
+	if b.shared != nil {
+		b.copyShared()
+	}
+}
+
+// copyShared copies the shared words of b, unless b is the last BitSet
+// referring to them. The reference is given up only once the copy is
+// complete: until then, the other BitSets sharing the words cannot be the
+// last one, so none of them can take over the words and modify them while
+// they are being copied.
+func (b *BitSet) copyShared() {
+	if atomic.LoadInt32(&b.shared.refs) > 1 {
+		set := make([]uint64, len(b.set))
+		copy(set, b.set)
+		b.set = set
+	}
+	atomic.AddInt32(&b.shared.refs, -1)
+	b.shared = nil
+}
+
+// release gives up the shared words of b, if any, when they are replaced.
+func (b *BitSet) release() {
+	if b.shared != nil {
+		atomic.AddInt32(&b.shared.refs, -1)
+		b.shared = nil
+	}
+}
+
 // Copy into a destination BitSet using the Go array copy semantics:
 // the number of bits copied is the minimum of the number of bits in the current
 // BitSet (Len()) and the destination Bitset.
 // We return the number of bits copied in the destination BitSet.
+// The destination keeps its options, such as its JSON format and growth
+// policy (unlike with CopyFull).
 func (b *BitSet) Copy(c *BitSet) (count uint) {
 	if c == nil {
 		return
 	}
+	c.prepareWrite()
+	// We only write into the words needed for the length of the destination:
+	// its backing slice may be longer (see FromWithLength).
+	dst := c.set
+	if n := c.wordCount(); n < len(dst) {
+		dst = dst[:n]
+	}
 	if b.set != nil { // Copy should not modify current object
-		copy(c.set, b.set)
+		copy(dst, b.set)
 	}
 	count = c.length
 	if b.length < c.length {
@@ -812,16 +1858,22 @@ func (b *BitSet) Copy(c *BitSet) (count uint) {
 	}
 	// Cleaning the last word is needed to keep the invariant that other functions, such as Count, require
 	// that any bits in the last word that would exceed the length of the bitmask are set to 0.
-	c.cleanLastWord()
+	if len(dst) > 0 && !c.isLenExactMultiple() {
+		dst[len(dst)-1] &= allBits >> (wordSize - wordsIndex(c.length))
+	}
 	return
 }
 
 // CopyFull copies into a destination BitSet such that the destination is
 // identical to the source after the operation, allocating memory if necessary.
+// The destination takes the options of the BitSet, such as its JSON format
+// and growth policy.
 func (b *BitSet) CopyFull(c *BitSet) {
 	if c == nil {
 		return
 	}
+	c.prepareWrite()
+	b.copyOptions(c)
 	c.length = b.length
 	if len(b.set) == 0 {
 		if c.set != nil {
@@ -846,6 +1898,159 @@ func (b *BitSet) Count() uint {
 	return 0
 }
 
+// Parity returns the XOR of all the bits of the BitSet, that is, true if
+// and only if Count() is odd. It XOR-folds the words and computes a single
+// population count, which is faster than Count.
+func (b *BitSet) Parity() bool {
+	if b == nil {
+		return false
+	}
+	var x uint64
+	for _, w := range b.usedWords() {
+		x ^= w
+	}
+	return bits.OnesCount64(x)&1 == 1
+}
+
+// SafeCount returns the number of set bits, treating a nil BitSet as an
+// empty set (it returns 0). It is the nil-safe counterpart of SafeTest.
+func (b *BitSet) SafeCount() uint {
+	if b == nil {
+		return 0
+	}
+	return b.Count()
+}
+
+// CountApprox estimates the number of set bits by counting the set bits
+// of sampleWords words chosen at random (with replacement, using the
+// default source of math/rand), and scaling the result to all the words
+// needed for Len() bits. It is much cheaper than Count for huge sets when
+// an estimate suffices. When sampleWords is at least the number of words,
+// it returns the exact Count, and when it is not positive, it returns 0.
+//
+// The estimate is unbiased. With W words, its standard deviation is at most
+// 32*W/sqrt(sampleWords), and about 8*W*sqrt(p*(1-p)/sampleWords) when the
+// bits are set independently with probability p: with 10000 sampled words,
+// the relative error on a set of density 0.5 is typically below 0.25%.
+// Sets with clustered bits lead to larger errors.
+func (b *BitSet) CountApprox(sampleWords int) uint {
+	words := b.usedWords()
+	if sampleWords >= len(words) {
+		return b.Count()
+	}
+	if sampleWords <= 0 {
+		return 0
+	}
+	var cnt int
+	for k := 0; k < sampleWords; k++ {
+		cnt += bits.OnesCount64(words[rand.Intn(len(words))])
+	}
+	return uint(float64(cnt)*float64(len(words))/float64(sampleWords) + 0.5)
+}
+
+// CountMatchingWords returns the number of 64-bit words w, among the words
+// needed to store Len() bits, such that w&mask == pattern. For example, with
+// mask set to all ones, it counts the words that are exactly equal to pattern.
+// It is meant for advanced users, see Words.
+func (b *BitSet) CountMatchingWords(mask, pattern uint64) uint {
+	var count uint
+	for _, w := range b.usedWords() {
+		if w&mask == pattern {
+			count++
+		}
+	}
+	return count
+}
+
+// WordHistogram classifies the 64-bit words needed to store Len() bits:
+// it returns the number of words equal to 0, of words with all 64 bits set,
+// and of the other (mixed) words. When Len() is not a multiple of 64, the
+// last word has clear bits beyond Len(), so it is never counted as all ones.
+// This informs the choice of a compressed encoding, such as run-length
+// encoding, which works well with many uniform words.
+func (b *BitSet) WordHistogram() (zeros, ones, mixed int) {
+	for _, w := range b.usedWords() {
+		switch w {
+		case 0:
+			zeros++
+		case allBits:
+			ones++
+		default:
+			mixed++
+		}
+	}
+	return zeros, ones, mixed
+}
+
+// RunCount returns the number of runs of set bits, that is, of maximal
+// intervals of consecutive set bits. Equivalently, it is the number of
+// positions i where bit i is set and bit i-1 is clear (or i is 0).
+func (b *BitSet) RunCount() uint {
+	var cnt int
+	var carry uint64 // most significant bit of the previous word
+	for _, word := range b.usedWords() {
+		// a run starts where a bit is set and the preceding bit is clear
+		cnt += bits.OnesCount64(word &^ (word<<1 | carry))
+		carry = word >> wordMask
+	}
+	return uint(cnt)
+}
+
+// CountAdjacentPairs returns the number of positions i where both bit i
+// and bit i+1 are set. A run of k consecutive set bits contributes k-1 pairs.
+func (b *BitSet) CountAdjacentPairs() uint {
+	var cnt int
+	var carry uint64 // most significant bit of the previous word
+	for _, word := range b.usedWords() {
+		// bit i of word<<1|carry is bit i-1 of the BitSet
+		cnt += bits.OnesCount64(word & (word<<1 | carry))
+		carry = word >> wordMask
+	}
+	return uint(cnt)
+}
+
+// LongestSetRun returns the start and the length of the longest run of
+// consecutive set bits. When several runs have the maximal length, the
+// first one is returned. For a BitSet with no set bit, it returns (0, 0).
+func (b *BitSet) LongestSetRun() (start, length uint) {
+	var curStart, curLen uint
+	for x, word := range b.usedWords() {
+		base := uint(x) << log2WordSize
+		if word == allBits {
+			if curLen == 0 {
+				curStart = base
+			}
+			curLen += wordSize
+			continue
+		}
+		for pos := uint(0); pos < wordSize; {
+			w := word >> pos
+			if w&1 == 0 {
+				// the current run, if any, ends here
+				if curLen > length {
+					start, length = curStart, curLen
+				}
+				curLen = 0
+				if w == 0 {
+					break
+				}
+				pos += uint(bits.TrailingZeros64(w))
+				continue
+			}
+			if curLen == 0 {
+				curStart = base + pos
+			}
+			ones := uint(bits.TrailingZeros64(^w))
+			curLen += ones
+			pos += ones
+		}
+	}
+	if curLen > length {
+		start, length = curStart, curLen
+	}
+	return start, length
+}
+
 // Equal tests the equivalence of two BitSets.
 // False if they are of different sizes, otherwise true
 // only if all the same bits are set
@@ -880,6 +2085,78 @@ func panicIfNull(b *BitSet) {
 	}
 }
 
+// FirstDifference returns the lowest index at which b and other differ,
+// along with true, or false when they are Equal. Bits beyond the length of
+// a BitSet are considered clear. When the bits agree but the lengths
+// differ, the first difference is the smaller length, the first index that
+// belongs to one BitSet and not to the other. This is convenient to report
+// why two BitSets are not equal.
+func (b *BitSet) FirstDifference(other *BitSet) (uint, bool) {
+	panicIfNull(b)
+	panicIfNull(other)
+	aw, bw := b.usedWords(), other.usedWords()
+	n := len(aw)
+	if len(bw) > n {
+		n = len(bw)
+	}
+	for i := 0; i < n; i++ {
+		if x := wordAt(aw, i) ^ wordAt(bw, i); x != 0 {
+			return uint(i<<log2WordSize + bits.TrailingZeros64(x)), true
+		}
+	}
+	switch {
+	case b.length < other.length:
+		return b.length, true
+	case other.length < b.length:
+		return other.length, true
+	}
+	return 0, false
+}
+
+// DiffRanges compares the BitSet with other, a previous version for
+// example, and returns the maximal intervals [start, end) of the bits set
+// in b but not in other (added), and of the bits set in other but not in b
+// (removed), in increasing order. This is convenient to render the changes
+// between two versions. Bits beyond the length of a BitSet are considered clear.
+func (b *BitSet) DiffRanges(other *BitSet) (added, removed [][2]uint) {
+	panicIfNull(b)
+	panicIfNull(other)
+	aw, bw := b.usedWords(), other.usedWords()
+	n := len(aw)
+	if len(bw) > n {
+		n = len(bw)
+	}
+	for i := 0; i < n; i++ {
+		x, y := wordAt(aw, i), wordAt(bw, i)
+		if x != y {
+			base := uint(i) << log2WordSize
+			added = appendRuns(added, x&^y, base)
+			removed = appendRuns(removed, y&^x, base)
+		}
+	}
+	return added, removed
+}
+
+// appendRuns appends to ranges the runs of set bits of w, a word holding
+// the bits from base, as intervals [start, end). A run that continues the
+// last interval of ranges extends it.
+func appendRuns(ranges [][2]uint, w uint64, base uint) [][2]uint {
+	for w != 0 {
+		start := uint(bits.TrailingZeros64(w))
+		end := start + uint(bits.TrailingZeros64(^(w >> start)))
+		if k := len(ranges) - 1; k >= 0 && ranges[k][1] == base+start {
+			ranges[k][1] = base + end
+		} else {
+			ranges = append(ranges, [2]uint{base + start, base + end})
+		}
+		if end == wordSize {
+			break
+		}
+		w &^= (1 << end) - 1
+	}
+	return ranges
+}
+
 // Difference of base set and other set
 // This is the BitSet equivalent of &^ (and not)
 func (b *BitSet) Difference(compare *BitSet) (result *BitSet) {
@@ -914,6 +2191,7 @@ func (b *BitSet) DifferenceCardinality(compare *BitSet) uint {
 // This is the BitSet equivalent of &^ (and not)
 func (b *BitSet) InPlaceDifference(compare *BitSet) {
 	panicIfNull(b)
+	b.prepareWrite()
 	panicIfNull(compare)
 	l := compare.wordCount()
 	if l > b.wordCount() {
@@ -931,6 +2209,21 @@ func (b *BitSet) InPlaceDifference(compare *BitSet) {
 	}
 }
 
+// RemoveSet clears from the base set every bit that is set in the other set,
+// and returns the base set. It is equivalent to InPlaceDifference.
+func (b *BitSet) RemoveSet(other *BitSet) *BitSet {
+	b.InPlaceDifference(other)
+	return b
+}
+
+// RemoveSetCount clears from the base set every bit that is set in the other set,
+// and returns the number of bits that were removed (i.e., that were set in both sets).
+func (b *BitSet) RemoveSetCount(other *BitSet) uint {
+	count := b.IntersectionCardinality(other)
+	b.InPlaceDifference(other)
+	return count
+}
+
 // Convenience function: return two bitsets ordered by
 // increasing length. Note: neither can be nil
 func sortByLength(a *BitSet, b *BitSet) (ap *BitSet, bp *BitSet) {
@@ -948,10 +2241,10 @@ func sortByLength(a *BitSet, b *BitSet) (ap *BitSet, bp *BitSet) {
 func (b *BitSet) Intersection(compare *BitSet) (result *BitSet) {
 	panicIfNull(b)
 	panicIfNull(compare)
-	b, compare = sortByLength(b, compare)
-	result = New(b.length)
-	for i, word := range b.set {
-		result.set[i] = word & compare.set[i]
+	small, large := sortByLength(b, compare)
+	result = b.copyOptions(New(small.length))
+	for i, word := range small.set {
+		result.set[i] = word & large.set[i]
 	}
 	return
 }
@@ -965,11 +2258,20 @@ func (b *BitSet) IntersectionCardinality(compare *BitSet) uint {
 	return uint(cnt)
 }
 
+// CountAt returns how many of the given positions are set in the BitSet,
+// that is, the number of bits set in both the BitSet and positions: it is
+// a masked count. It is the same as IntersectionCardinality. Positions at
+// or beyond Len() are counted as clear.
+func (b *BitSet) CountAt(positions *BitSet) uint {
+	return b.IntersectionCardinality(positions)
+}
+
 // InPlaceIntersection destructively computes the intersection of
 // base set and the compare set.
 // This is the BitSet equivalent of & (and)
 func (b *BitSet) InPlaceIntersection(compare *BitSet) {
 	panicIfNull(b)
+	b.prepareWrite()
 	panicIfNull(compare)
 	l := compare.wordCount()
 	if l > b.wordCount() {
@@ -1002,10 +2304,10 @@ func (b *BitSet) InPlaceIntersection(compare *BitSet) {
 func (b *BitSet) Union(compare *BitSet) (result *BitSet) {
 	panicIfNull(b)
 	panicIfNull(compare)
-	b, compare = sortByLength(b, compare)
-	result = compare.Clone()
-	for i, word := range b.set {
-		result.set[i] = word | compare.set[i]
+	small, large := sortByLength(b, compare)
+	result = b.copyOptions(large.Clone())
+	for i, word := range small.set {
+		result.set[i] = word | large.set[i]
 	}
 	return
 }
@@ -1027,6 +2329,7 @@ func (b *BitSet) UnionCardinality(compare *BitSet) uint {
 // This is the BitSet equivalent of | (or).
 func (b *BitSet) InPlaceUnion(compare *BitSet) {
 	panicIfNull(b)
+	b.prepareWrite()
 	panicIfNull(compare)
 	l := compare.wordCount()
 	if l > b.wordCount() {
@@ -1045,11 +2348,145 @@ func (b *BitSet) InPlaceUnion(compare *BitSet) {
 			data[i] |= cmpData[i]
 		}
 	}
-	if len(compare.set) > l {
-		for i := l; i < len(compare.set); i++ {
-			b.set[i] = compare.set[i]
-		}
+	if len(compare.set) > l {
+		for i := l; i < len(compare.set); i++ {
+			b.set[i] = compare.set[i]
+		}
+	}
+}
+
+// UnionLen returns the length of the union of b and other, which is the
+// maximum of their lengths, without computing the union. It is also the
+// length of their symmetric difference. Use it to presize the destination
+// of UnionInto or SymmetricDifferenceInto.
+func (b *BitSet) UnionLen(other *BitSet) uint {
+	panicIfNull(b)
+	panicIfNull(other)
+	if other.length > b.length {
+		return other.length
+	}
+	return b.length
+}
+
+// IntersectionLen returns the length of the intersection of b and other,
+// which is the minimum of their lengths, without computing the intersection.
+// Use it to presize the destination of IntersectionInto.
+func (b *BitSet) IntersectionLen(other *BitSet) uint {
+	panicIfNull(b)
+	panicIfNull(other)
+	if other.length < b.length {
+		return other.length
+	}
+	return b.length
+}
+
+// UnionInto computes the union of a and b into dst and returns the
+// resulting length, which is the maximum of the lengths of a and b.
+// This is the BitSet equivalent of | (or).
+// Unlike Union, it does not allocate a new BitSet: the backing slice of
+// dst is reused, and no memory is allocated when dst has enough capacity.
+// The destination dst may be the same BitSet as a or b.
+func UnionInto(dst, a, b *BitSet) uint {
+	panicIfNull(dst)
+	panicIfNull(a)
+	panicIfNull(b)
+	length := a.UnionLen(b)
+	aw, bw, data := prepareInto(dst, a, b, length)
+	for i := range data {
+		data[i] = wordAt(aw, i) | wordAt(bw, i)
+	}
+	return length
+}
+
+// IntersectionInto computes the intersection of a and b into dst and returns
+// the resulting length, which is the minimum of the lengths of a and b.
+// This is the BitSet equivalent of & (and).
+// Unlike Intersection, it does not allocate a new BitSet: the backing slice of
+// dst is reused, and no memory is allocated when dst has enough capacity.
+// The destination dst may be the same BitSet as a or b.
+func IntersectionInto(dst, a, b *BitSet) uint {
+	panicIfNull(dst)
+	panicIfNull(a)
+	panicIfNull(b)
+	length := a.IntersectionLen(b)
+	aw, bw, data := prepareInto(dst, a, b, length)
+	for i := range data {
+		data[i] = wordAt(aw, i) & wordAt(bw, i)
+	}
+	return length
+}
+
+// DifferenceInto computes the difference of a and b into dst and returns
+// the resulting length, which is the length of a.
+// This is the BitSet equivalent of &^ (and not).
+// Unlike Difference, it does not allocate a new BitSet: the backing slice of
+// dst is reused, and no memory is allocated when dst has enough capacity.
+// The destination dst may be the same BitSet as a or b.
+func DifferenceInto(dst, a, b *BitSet) uint {
+	panicIfNull(dst)
+	panicIfNull(a)
+	panicIfNull(b)
+	length := a.length
+	aw, bw, data := prepareInto(dst, a, b, length)
+	for i := range data {
+		data[i] = wordAt(aw, i) &^ wordAt(bw, i)
+	}
+	return length
+}
+
+// SymmetricDifferenceInto computes the symmetric difference of a and b into
+// dst and returns the resulting length, which is the maximum of the lengths
+// of a and b.
+// This is the BitSet equivalent of ^ (xor).
+// Unlike SymmetricDifference, it does not allocate a new BitSet: the backing
+// slice of dst is reused, and no memory is allocated when dst has enough capacity.
+// The destination dst may be the same BitSet as a or b.
+func SymmetricDifferenceInto(dst, a, b *BitSet) uint {
+	panicIfNull(dst)
+	panicIfNull(a)
+	panicIfNull(b)
+	length := a.UnionLen(b)
+	aw, bw, data := prepareInto(dst, a, b, length)
+	for i := range data {
+		data[i] = wordAt(aw, i) ^ wordAt(bw, i)
+	}
+	return length
+}
+
+// Select3 returns a new BitSet whose bit i is the bit i of a when the bit i
+// of cond is set, and the bit i of b otherwise: it is a bitwise multiplexer,
+// computing (cond & a) | (^cond & b) a word at a time.
+// The three BitSets are zero-extended to the same length, so that the length
+// of the result is the maximum of the lengths of a and b.
+func Select3(cond, a, b *BitSet) *BitSet {
+	panicIfNull(cond)
+	panicIfNull(a)
+	panicIfNull(b)
+	length := a.UnionLen(b)
+	result := New(length)
+	cw, aw, bw := cond.usedWords(), a.usedWords(), b.usedWords()
+	for i := range result.set {
+		c := wordAt(cw, i)
+		result.set[i] = (c & wordAt(aw, i)) | (^c & wordAt(bw, i))
+	}
+	return result
+}
+
+// prepareInto resizes dst to the given length in bits for a three-operand
+// operation on a and b, and returns the words of a, b and dst. The words of
+// a and b are captured before resizing dst, since dst may alias a or b.
+func prepareInto(dst, a, b *BitSet, length uint) (aw, bw, data []uint64) {
+	aw, bw = a.usedWords(), b.usedWords()
+	dst.resizeTo(length)
+	return aw, bw, dst.set
+}
+
+// wordAt returns w[i], or 0 if i is beyond the end of w.
+func wordAt(w []uint64, i int) uint64 {
+	if i < len(w) {
+		return w[i]
 	}
+	return 0
 }
 
 // SymmetricDifference of base set and other set
@@ -1057,11 +2494,11 @@ func (b *BitSet) InPlaceUnion(compare *BitSet) {
 func (b *BitSet) SymmetricDifference(compare *BitSet) (result *BitSet) {
 	panicIfNull(b)
 	panicIfNull(compare)
-	b, compare = sortByLength(b, compare)
-	// compare is bigger, so clone it
-	result = compare.Clone()
-	for i, word := range b.set {
-		result.set[i] = word ^ compare.set[i]
+	small, large := sortByLength(b, compare)
+	// large is bigger, so clone it
+	result = b.copyOptions(large.Clone())
+	for i, word := range small.set {
+		result.set[i] = word ^ large.set[i]
 	}
 	return
 }
@@ -1078,10 +2515,69 @@ func (b *BitSet) SymmetricDifferenceCardinality(compare *BitSet) uint {
 	return uint(cnt)
 }
 
+// SymmetricDifferenceCardinalityRange computes the cardinality of the
+// symmetric difference restricted to the range [start, end), that is, the
+// number of indexes in [start, end) where the two sets differ. Bits beyond
+// the length of a set are considered clear.
+func (b *BitSet) SymmetricDifferenceCardinalityRange(compare *BitSet, start, end uint) uint {
+	panicIfNull(b)
+	panicIfNull(compare)
+	if start >= end {
+		return 0
+	}
+	bw, cw := b.usedWords(), compare.usedWords()
+	n := len(bw)
+	if len(cw) > n {
+		n = len(cw)
+	}
+	startWord := int(start >> log2WordSize)
+	lastWord := int((end - 1) >> log2WordSize)
+	var cnt int
+	for i := startWord; i <= lastWord && i < n; i++ {
+		w := wordAt(bw, i) ^ wordAt(cw, i)
+		if i == startWord {
+			w &= allBits << wordsIndex(start) // bits >= start
+		}
+		if i == lastWord {
+			w &= allBits >> (wordMask - wordsIndex(end-1)) // bits < end
+		}
+		cnt += bits.OnesCount64(w)
+	}
+	return uint(cnt)
+}
+
+// ParityCardinality returns the number of positions that are set in an odd
+// number of the given BitSets, that is, the number of set bits of their
+// symmetric difference. It generalizes SymmetricDifferenceCardinality to any
+// number of BitSets, XOR-folding their words in a single pass without
+// building the symmetric difference. BitSets of different lengths are
+// zero-extended.
+func ParityCardinality(sets ...*BitSet) uint {
+	words := make([][]uint64, len(sets))
+	n := 0
+	for k, s := range sets {
+		panicIfNull(s)
+		words[k] = s.usedWords()
+		if len(words[k]) > n {
+			n = len(words[k])
+		}
+	}
+	var cnt int
+	for i := 0; i < n; i++ {
+		var x uint64
+		for _, w := range words {
+			x ^= wordAt(w, i)
+		}
+		cnt += bits.OnesCount64(x)
+	}
+	return uint(cnt)
+}
+
 // InPlaceSymmetricDifference creates the destructive SymmetricDifference of base set and other set
 // This is the BitSet equivalent of ^ (xor)
 func (b *BitSet) InPlaceSymmetricDifference(compare *BitSet) {
 	panicIfNull(b)
+	b.prepareWrite()
 	panicIfNull(compare)
 	l := compare.wordCount()
 	if l > b.wordCount() {
@@ -1118,11 +2614,55 @@ func (b *BitSet) cleanLastWord() {
 	}
 }
 
+// CheckInvariants verifies the internal consistency of the BitSet and
+// returns a non-nil error describing the first problem found. Many
+// functions, such as Count, require that the backing slice holds at
+// least (Len()+63)/64 words and that no bit at or beyond Len() is set.
+// These invariants may be broken by modifying the slice returned by
+// Words or by using FromWithLength improperly.
+// It is meant for debugging and testing.
+func (b *BitSet) CheckInvariants() error {
+	if b == nil {
+		return errors.New("invariant violation: nil BitSet")
+	}
+	n := wordsNeededUnbound(b.length)
+	if len(b.set) < n {
+		return fmt.Errorf("invariant violation: %d words are needed for %d bits, but only %d are present", n, b.length, len(b.set))
+	}
+	if !b.isLenExactMultiple() && b.set[n-1]&^(allBits>>(wordSize-wordsIndex(b.length))) != 0 {
+		return fmt.Errorf("invariant violation: bits are set beyond the length %d in word %d", b.length, n-1)
+	}
+	for i := n; i < len(b.set); i++ {
+		if b.set[i] != 0 {
+			return fmt.Errorf("invariant violation: bits are set beyond the length %d in word %d", b.length, i)
+		}
+	}
+	return nil
+}
+
+// Canonicalize restores the invariants checked by CheckInvariants after the
+// BitSet was built from an external slice, for example with FromWithLength
+// or SetBitsetFromWithLength: it clears the bits of the last word at or
+// beyond Len(), and it trims the backing slice to the (Len()+63)/64 words
+// needed, so that stray words beyond them are never reused when the BitSet
+// grows. It panics if the slice is too short. It returns the BitSet.
+func (b *BitSet) Canonicalize() *BitSet {
+	panicIfNull(b)
+	n := wordsNeeded(b.length)
+	if len(b.set) < n {
+		panic("BitSet.Canonicalize: slice is too short")
+	}
+	b.prepareWrite()
+	b.set = b.set[:n:n]
+	b.cleanLastWord()
+	return b
+}
+
 // Complement computes the (local) complement of a bitset (up to length bits)
 // In case of allocation failure, the function will return an empty BitSet.
 func (b *BitSet) Complement() (result *BitSet) {
 	panicIfNull(b)
-	result = New(b.length)
+	result = b.copyOptions(New(b.length))
 	for i, word := range b.set {
 		result.set[i] = ^word
 	}
@@ -1130,11 +2670,111 @@ func (b *BitSet) Complement() (result *BitSet) {
 	return
 }
 
+// RelativeComplement computes the complement of the base set within the
+// universe set: the result holds the bits that are set in universe but
+// not in the base set, and its length is the length of universe.
+// It is equivalent to universe.Difference(b).
+func (b *BitSet) RelativeComplement(universe *BitSet) *BitSet {
+	panicIfNull(b)
+	panicIfNull(universe)
+	return universe.Difference(b)
+}
+
+// PrefixXor returns a new BitSet, of the same length, where bit i is the
+// parity (exclusive or) of the bits 0 to i of the BitSet. It is the bit-level
+// analog of a prefix sum modulo 2.
+func (b *BitSet) PrefixXor() *BitSet {
+	panicIfNull(b)
+	result := b.Clone()
+	var parity uint64 // parity of all the previous words, 0 or allBits
+	for i, x := range result.set {
+		// prefix xor within the word, in log2(64) steps
+		x ^= x << 1
+		x ^= x << 2
+		x ^= x << 4
+		x ^= x << 8
+		x ^= x << 16
+		x ^= x << 32
+		x ^= parity
+		result.set[i] = x
+		parity = -(x >> wordMask)
+	}
+	if len(result.set) > 0 {
+		result.cleanLastWord()
+	}
+	return result
+}
+
+// ToGray returns a new BitSet, of the same length, holding the reflected
+// binary Gray code of the BitSet interpreted as an integer (bit i having
+// weight 2^i), that is, value ^ (value >> 1): bit i of the result is the
+// exclusive or of the bits i and i+1 of the BitSet. See FromGray for the
+// inverse.
+func (b *BitSet) ToGray() *BitSet {
+	panicIfNull(b)
+	result := b.Clone()
+	for i, x := range result.set {
+		// the lowest bit of the next word is shifted into the top of this one
+		result.set[i] = x ^ (x>>1 | wordAt(result.set, i+1)<<wordMask)
+	}
+	return result
+}
+
+// FromGray is the inverse of ToGray: it returns a new BitSet, of the same
+// length, where bit i is the parity (exclusive or) of the bits i and above
+// of the BitSet, which holds a reflected binary Gray code.
+func (b *BitSet) FromGray() *BitSet {
+	panicIfNull(b)
+	result := b.Clone()
+	var parity uint64 // parity of all the following words, 0 or allBits
+	for i := len(result.set) - 1; i >= 0; i-- {
+		// suffix xor within the word, in log2(64) steps
+		x := result.set[i]
+		x ^= x >> 1
+		x ^= x >> 2
+		x ^= x >> 4
+		x ^= x >> 8
+		x ^= x >> 16
+		x ^= x >> 32
+		x ^= parity
+		result.set[i] = x
+		parity = -(x & 1)
+	}
+	if len(result.set) > 0 {
+		result.cleanLastWord()
+	}
+	return result
+}
+
 // All returns true if all bits are set, false otherwise. Returns true for
 // empty sets.
 func (b *BitSet) All() bool {
 	panicIfNull(b)
-	return b.Count() == b.length
+	words := b.usedWords()
+	full := int(b.length >> log2WordSize) // number of words where all bits are within the length
+	for _, word := range words[:full] {
+		if word != allBits {
+			return false
+		}
+	}
+	if full < len(words) {
+		// the last word is partially used
+		mask := allBits >> (wordSize - wordsIndex(b.length))
+		return words[full] == mask
+	}
+	return true
+}
+
+// IsFull returns true if all bits are set, false otherwise. It is the
+// same as All: it returns true for empty sets.
+func (b *BitSet) IsFull() bool {
+	return b.All()
+}
+
+// IsEmpty returns true if no bit is set, false otherwise. It is the
+// same as None: it returns true for empty sets.
+func (b *BitSet) IsEmpty() bool {
+	return b.None()
 }
 
 // None returns true if no bit is set, false otherwise. Returns true for
@@ -1151,6 +2791,21 @@ func (b *BitSet) None() bool {
 	return true
 }
 
+// IsSingleton returns true if exactly one bit is set.
+func (b *BitSet) IsSingleton() bool {
+	panicIfNull(b)
+	found := false
+	for _, word := range b.set {
+		if word != 0 {
+			if found || word&(word-1) != 0 {
+				return false
+			}
+			found = true
+		}
+	}
+	return found
+}
+
 // Any returns true if any bit is set, false otherwise
 func (b *BitSet) Any() bool {
 	panicIfNull(b)
@@ -1176,6 +2831,18 @@ func (b *BitSet) IsStrictSuperSet(other *BitSet) bool {
 	return b.Count() > other.Count() && b.IsSuperSet(other)
 }
 
+// IsSubSet returns true if this is a subset of the other set.
+// It is equivalent to other.IsSuperSet(b).
+func (b *BitSet) IsSubSet(other *BitSet) bool {
+	return other.IsSuperSet(b)
+}
+
+// IsStrictSubSet returns true if this is a strict subset of the other set.
+// It is equivalent to other.IsStrictSuperSet(b).
+func (b *BitSet) IsStrictSubSet(other *BitSet) bool {
+	return other.IsStrictSuperSet(b)
+}
+
 // DumpAsBits dumps a bit set as a string of bits. Following the usual convention in Go,
 // the least significant bits are printed last (index 0 is at the end of the string).
 // This is useful for debugging and testing. It is not suitable for serialization.
@@ -1191,11 +2858,77 @@ func (b *BitSet) DumpAsBits() string {
 	return buffer.String()
 }
 
+// DumpAsBitsGrouped dumps a bit set as a string of bits, one word of 64
+// bits per group, with wordsPerLine groups per line (at least 1). Each line
+// starts with the range of the bit indexes it covers, and the words follow
+// in increasing order, separated by spaces. Like in DumpAsBits, each word is
+// printed with its least significant bit last: the bit 64*k is the last
+// character of the kth group. Only the words needed to store Len() bits are
+// printed. This is useful for debugging large bit sets.
+func (b *BitSet) DumpAsBitsGrouped(wordsPerLine int) string {
+	if wordsPerLine < 1 {
+		wordsPerLine = 1
+	}
+	words := b.usedWords()
+	var buffer strings.Builder
+	for start := 0; start < len(words); start += wordsPerLine {
+		end := start + wordsPerLine
+		if end > len(words) {
+			end = len(words)
+		}
+		fmt.Fprintf(&buffer, "[%d, %d):", start*wordSize, end*wordSize)
+		for _, w := range words[start:end] {
+			fmt.Fprintf(&buffer, " %064b", w)
+		}
+		buffer.WriteByte('\n')
+	}
+	return buffer.String()
+}
+
+// WritePBM writes the BitSet as a NetPBM bitmap image (binary P4 format),
+// interpreting it as a two-dimensional grid with the given width in row-major
+// order: bit y*width+x is the pixel at column x and row y. Set bits are
+// black pixels. The height of the image is (Len()+width-1)/width: the last
+// row is padded with clear bits. This is useful to visualize large bitsets.
+func (b *BitSet) WritePBM(w io.Writer, width uint) error {
+	if width == 0 {
+		return errors.New("invalid width: 0")
+	}
+	height := (b.length + width - 1) / width
+	if _, err := fmt.Fprintf(w, "P4\n%d %d\n", width, height); err != nil {
+		return err
+	}
+	// in P4, each row is packed into whole bytes, most significant bit first
+	row := make([]byte, (width+7)/8)
+	for y := uint(0); y < height; y++ {
+		for k := range row {
+			row[k] = 0
+		}
+		for x := uint(0); x < width; x++ {
+			if b.Test(y*width + x) {
+				row[x>>3] |= 0x80 >> (x & 7)
+			}
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // BinaryStorageSize returns the binary storage requirements (see WriteTo) in bytes.
 func (b *BitSet) BinaryStorageSize() int {
 	return wordBytes + wordBytes*b.wordCount()
 }
 
+// SizeInBytes returns the memory used by the BitSet in bytes: the size of the
+// BitSet structure itself plus the full capacity of its backing slice.
+// It is useful to report memory usage. It differs from BinaryStorageSize,
+// which is the size of the serialized BitSet.
+func (b *BitSet) SizeInBytes() uintptr {
+	return unsafe.Sizeof(*b) + uintptr(cap(b.set))*wordBytes
+}
+
 func readUint64Array(reader io.Reader, data []uint64) error {
 	length := len(data)
 	bufferSize := 128
@@ -1238,6 +2971,107 @@ func writeUint64Array(writer io.Writer, data []uint64) error {
 	return nil
 }
 
+// WriteIndicesText writes the indexes of the set bits to w as decimal text,
+// one per line, in increasing order, and returns the number of bytes
+// written to w. Unlike String, it streams the output through a small
+// buffer, without building the whole text in memory. The output is
+// convenient for text tools such as grep or awk; see ReadIndicesText for
+// the inverse.
+func (b *BitSet) WriteIndicesText(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	buffer := bufio.NewWriter(counter)
+	var line []byte
+	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+		line = strconv.AppendUint(line[:0], uint64(i), 10)
+		line = append(line, '\n')
+		if _, err := buffer.Write(line); err != nil {
+			return counter.n, err
+		}
+	}
+	err := buffer.Flush()
+	return counter.n, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadIndicesText builds a BitSet from decimal indexes separated by white
+// space or newlines, such as the output of WriteIndicesText. Blank lines
+// are skipped, and there is no limit on the length of a line. The length of
+// the result is the largest index plus one. A malformed index, or an index
+// beyond the limit set by SetMaxDecodedLength, is reported along with its
+// line number.
+func ReadIndicesText(r io.Reader) (*BitSet, error) {
+	b := New(0)
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanIndexText)
+	line := 1
+	for scanner.Scan() {
+		field := scanner.Text()
+		if field == "\n" {
+			line++
+			continue
+		}
+		i, err := strconv.ParseUint(field, 10, strconv.IntSize)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid index %q: %w", line, field, err)
+		}
+		if uint(i) >= maxDecodedLength {
+			return nil, fmt.Errorf("line %d: index %d exceeds the limit of %d bits, see SetMaxDecodedLength", line, i, maxDecodedLength)
+		}
+		if uint(i) >= b.length {
+			if err := b.tryExtendSet(uint(i)); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		b.Set(uint(i))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	return b, nil
+}
+
+// scanIndexText is a bufio.SplitFunc for ReadIndicesText. Like
+// bufio.ScanWords, it returns the words separated by white space, but it
+// also returns each newline as a token, so that the lines can be counted.
+func scanIndexText(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	start := 0
+	for start < len(data) && data[start] != '\n' && isTextSpace(data[start]) {
+		start++
+	}
+	if start < len(data) && data[start] == '\n' {
+		return start + 1, data[start : start+1], nil
+	}
+	for i := start; i < len(data); i++ {
+		if isTextSpace(data[i]) {
+			return i, data[start:i], nil
+		}
+	}
+	if atEOF && len(data) > start {
+		return len(data), data[start:], nil
+	}
+	return start, nil, nil
+}
+
+// isTextSpace reports whether c is an ASCII white space character.
+func isTextSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
 // WriteTo writes a BitSet to a stream. The format is:
 // 1. uint64 length
 // 2. []uint64 set
@@ -1294,6 +3128,7 @@ func (b *BitSet) WriteTo(stream io.Writer) (int64, error) {
 //	f, err := os.Open("myfile")
 //	r := bufio.NewReader(f)
 func (b *BitSet) ReadFrom(stream io.Reader) (int64, error) {
+	b.prepareWrite()
 	var length uint64
 	err := binary.Read(stream, binaryOrder, &length)
 	if err != nil {
@@ -1351,8 +3186,168 @@ func (b *BitSet) UnmarshalBinary(data []byte) error {
 	return err
 }
 
-// MarshalJSON marshals a BitSet as a JSON structure
+// MergeBinary decodes a BitSet encoded by MarshalBinary (or WriteTo) and
+// merges it into b with a union, rather than replacing the content of b
+// like UnmarshalBinary. The length of b becomes the maximum of its length
+// and of the decoded length. The words are read directly from data: no
+// intermediate BitSet is allocated. On error, b is left unchanged.
+func (b *BitSet) MergeBinary(data []byte) error {
+	panicIfNull(b)
+	if len(data) < wordBytes {
+		return io.ErrUnexpectedEOF
+	}
+	length := binaryOrder.Uint64(data)
+	newlength := uint(length)
+	if uint64(newlength) != length {
+		return errors.New("unmarshalling error: type mismatch")
+	}
+	data = data[wordBytes:]
+	nWords := wordsNeeded(newlength)
+	if uint64(len(data))/wordBytes < uint64(nWords) {
+		return io.ErrUnexpectedEOF
+	}
+	if newlength == 0 {
+		return nil
+	}
+
+	b.prepareWrite()
+	if newlength > b.length {
+		b.extendSet(newlength - 1)
+	}
+	last := nWords - 1
+	for i := 0; i < last; i++ {
+		b.set[i] |= binaryOrder.Uint64(data[i*wordBytes:])
+	}
+	w := binaryOrder.Uint64(data[last*wordBytes:])
+	if r := wordsIndex(newlength); r != 0 {
+		// ignore the bits beyond the decoded length, if any were encoded
+		w &= allBits >> (wordSize - r)
+	}
+	b.set[last] |= w
+	return nil
+}
+
+// MarshalDeltaVarint encodes the BitSet as a sequence of unsigned varints
+// (see encoding/binary): first the length, then the gaps between the
+// successive set bits. The gap of a set bit i is i-(p+1), where p is the
+// previous set bit (or -1 for the first one), so that consecutive set bits
+// take a single byte. For sparse or clustered sets, such as posting lists,
+// the result is much smaller than with MarshalBinary.
+func (b *BitSet) MarshalDeltaVarint() []byte {
+	panicIfNull(b)
+	data := make([]byte, 0, binary.MaxVarintLen64+b.Count())
+	var buf [binary.MaxVarintLen64]byte
+	data = append(data, buf[:binary.PutUvarint(buf[:], uint64(b.length))]...)
+	var next uint
+	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+		data = append(data, buf[:binary.PutUvarint(buf[:], uint64(i-next))]...)
+		next = i + 1
+	}
+	return data
+}
+
+// UnmarshalDeltaVarint decodes a BitSet encoded by MarshalDeltaVarint.
+// The data is validated before the BitSet is allocated, and an error is
+// returned if the encoded length exceeds the limit set by
+// SetMaxDecodedLength.
+func UnmarshalDeltaVarint(data []byte) (*BitSet, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errors.New("unmarshalling error: invalid length")
+	}
+	if uint64(uint(length)) != length {
+		return nil, errors.New("unmarshalling error: type mismatch")
+	}
+	if uint(length) > maxDecodedLength {
+		return nil, errDecodedLength(length)
+	}
+	data = data[n:]
+	// the first pass only validates the gaps, the second one sets the bits
+	if err := decodeDeltaVarint(data, length, func(uint64) {}); err != nil {
+		return nil, err
+	}
+	b := &BitSet{}
+	if length > 0 {
+		if err := b.tryExtendSet(uint(length - 1)); err != nil {
+			return nil, fmt.Errorf("unmarshalling error: %w", err)
+		}
+	}
+	_ = decodeDeltaVarint(data, length, func(i uint64) {
+		b.set[i>>log2WordSize] |= 1 << wordsIndex(uint(i))
+	})
+	return b, nil
+}
+
+// decodeDeltaVarint calls fn for each index encoded as a gap in data (see
+// MarshalDeltaVarint), checking that the indexes are below length.
+func decodeDeltaVarint(data []byte, length uint64, fn func(i uint64)) error {
+	var next uint64
+	for len(data) > 0 {
+		gap, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("unmarshalling error: invalid gap")
+		}
+		data = data[n:]
+		i := next + gap
+		if i < next || i >= length {
+			return fmt.Errorf("unmarshalling error: index beyond the length %d", length)
+		}
+		fn(i)
+		next = i + 1
+	}
+	return nil
+}
+
+// JSONFormat selects how a BitSet is marshaled to JSON, see SetJSONFormat.
+type JSONFormat int
+
+const (
+	// JSONBase64 marshals the BitSet as a string holding the base64 encoding
+	// of the binary form (see WriteTo and Base64StdEncoding). It is the default.
+	JSONBase64 JSONFormat = iota
+	// JSONArray marshals the BitSet as an array of the indexes of the set
+	// bits, such as [0,1,5,64], which is convenient for web clients.
+	// The length of the BitSet is not preserved: after unmarshaling, it is
+	// the largest index plus one, which is bounded by SetMaxDecodedLength.
+	// Note that each index takes several bytes: for large dense sets, the
+	// output is much larger than with JSONBase64.
+	JSONArray
+	// JSONDeltaVarint marshals the BitSet as a string holding the prefix
+	// "dv:" followed by the base64 encoding of MarshalDeltaVarint. For sparse
+	// sets with large indexes, the output is much smaller than with JSONBase64.
+	JSONDeltaVarint
+	// JSONAuto selects JSONDeltaVarint when its output is smaller than with
+	// JSONBase64, and JSONBase64 otherwise.
+	JSONAuto
+)
+
+// jsonDeltaVarintPrefix starts the strings of the JSONDeltaVarint format.
+// The colon is not a base64 character, so that it cannot start a string
+// of the JSONBase64 format.
+const jsonDeltaVarintPrefix = "dv:"
+
+// SetJSONFormat selects the format used by MarshalJSON for this instance,
+// and returns the BitSet. The default is JSONBase64. UnmarshalJSON accepts
+// all formats, whatever the selected one.
+func (b *BitSet) SetJSONFormat(format JSONFormat) *BitSet {
+	b.jsonFormat = format
+	return b
+}
+
+// MarshalJSON marshals a BitSet as a JSON structure, using the format
+// selected with SetJSONFormat.
 func (b BitSet) MarshalJSON() ([]byte, error) {
+	if b.jsonFormat == JSONArray {
+		indexes := b.AppendTo(make([]uint, 0, b.Count()))
+		return json.Marshal(indexes)
+	}
+	if b.jsonFormat == JSONDeltaVarint || b.jsonFormat == JSONAuto {
+		data := b.MarshalDeltaVarint()
+		if b.jsonFormat == JSONDeltaVarint || len(data) < b.BinaryStorageSize() {
+			return json.Marshal(jsonDeltaVarintPrefix + base64Encoding.EncodeToString(data))
+		}
+	}
+
 	buffer := bytes.NewBuffer(make([]byte, 0, b.BinaryStorageSize()))
 	_, err := b.WriteTo(buffer)
 	if err != nil {
@@ -1363,8 +3358,13 @@ func (b BitSet) MarshalJSON() ([]byte, error) {
 	return json.Marshal(base64Encoding.EncodeToString(buffer.Bytes()))
 }
 
-// UnmarshalJSON unmarshals a BitSet from JSON created using MarshalJSON
+// UnmarshalJSON unmarshals a BitSet from JSON created using MarshalJSON.
+// The format (see JSONFormat) is detected automatically.
 func (b *BitSet) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimLeft(data, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '[' {
+		return b.unmarshalJSONArray(data)
+	}
+
 	// Unmarshal as string
 	var s string
 	err := json.Unmarshal(data, &s)
@@ -1372,6 +3372,20 @@ func (b *BitSet) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	if strings.HasPrefix(s, jsonDeltaVarintPrefix) {
+		buf, err := base64Encoding.DecodeString(s[len(jsonDeltaVarintPrefix):])
+		if err != nil {
+			return err
+		}
+		c, err := UnmarshalDeltaVarint(buf)
+		if err != nil {
+			return err
+		}
+		b.release()
+		b.length, b.set = c.length, c.set
+		return nil
+	}
+
 	// URLDecode string
 	buf, err := base64Encoding.DecodeString(s)
 	if err != nil {
@@ -1382,6 +3396,34 @@ func (b *BitSet) UnmarshalJSON(data []byte) error {
 	return err
 }
 
+// unmarshalJSONArray replaces the content of the BitSet with the indexes
+// found in a JSON array.
+func (b *BitSet) unmarshalJSONArray(data []byte) error {
+	var indexes []uint
+	if err := json.Unmarshal(data, &indexes); err != nil {
+		return err
+	}
+	var length uint
+	for _, i := range indexes {
+		if i >= maxDecodedLength {
+			return fmt.Errorf("unmarshalling error: index %d exceeds the limit of %d bits, see SetMaxDecodedLength", i, maxDecodedLength)
+		}
+		if i >= length {
+			length = i + 1
+		}
+	}
+	b.empty()
+	if length > 0 {
+		if err := b.tryExtendSet(length - 1); err != nil {
+			return fmt.Errorf("unmarshalling error: %w", err)
+		}
+	}
+	for _, i := range indexes {
+		b.set[i>>log2WordSize] |= 1 << wordsIndex(i)
+	}
+	return nil
+}
+
 // Rank returns the number of set bits up to and including the index
 // that are set in the bitset.
 // See https://en.wikipedia.org/wiki/Ranking#Ranking_in_statistics
@@ -1416,6 +3458,32 @@ func (b *BitSet) Select(index uint) uint {
 	return b.length
 }
 
+// Quantile returns the set bit of rank round(q*(Count()-1)), using the
+// nearest-rank convention of Select (the smallest set bit has rank 0), and
+// true. Thus Quantile(0) is the smallest set bit, Quantile(0.5) the median
+// and Quantile(1) the largest set bit. The value q is clamped to [0,1].
+// It returns false when the BitSet is empty.
+func (b *BitSet) Quantile(q float64) (uint, bool) {
+	count := b.Count()
+	if count == 0 {
+		return 0, false
+	}
+	if !(q > 0) { // also catches NaN
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+	return b.Select(uint(q*float64(count-1) + 0.5)), true
+}
+
+// HighestSet returns the index of the highest set bit, along with true,
+// or false when no bit is set. Unlike Len(), which may be much larger
+// (for example after New(1000).Set(5)), it depends only on the set bits.
+// It scans the words from the end.
+func (b *BitSet) HighestSet() (uint, bool) {
+	return b.top()
+}
+
 // top detects the top bit set
 func (b *BitSet) top() (uint, bool) {
 	panicIfNull(b)
@@ -1439,6 +3507,7 @@ func (b *BitSet) top() (uint, bool) {
 // The function will panic if shift causes excess of capacity.
 func (b *BitSet) ShiftLeft(bits uint) {
 	panicIfNull(b)
+	b.prepareWrite()
 
 	if bits == 0 {
 		return
@@ -1449,8 +3518,9 @@ func (b *BitSet) ShiftLeft(bits uint) {
 		return
 	}
 
-	// capacity check
-	if top+bits < bits {
+	// capacity check: the new top bit top+bits must be below Cap(),
+	// otherwise top+bits+1 wraps around and too few words are allocated
+	if bits >= Cap()-top {
 		panic("You are exceeding the capacity")
 	}
 
@@ -1458,7 +3528,7 @@ func (b *BitSet) ShiftLeft(bits uint) {
 	dst := b.set
 
 	// not using extendSet() to avoid unneeded data copying
-	nsize := wordsNeeded(top + bits)
+	nsize := wordsNeeded(top + bits + 1)
 	if len(b.set) < nsize {
 		dst = make([]uint64, nsize)
 	}
@@ -1492,9 +3562,109 @@ func (b *BitSet) ShiftLeft(bits uint) {
 	b.set = dst
 }
 
+// Shift shifts the bitset by delta positions: to the left (toward higher
+// indexes, like ShiftLeft) when delta is positive, and to the right (like
+// ShiftRight) when delta is negative. A delta of 0 leaves the BitSet unchanged.
+func (b *BitSet) Shift(delta int) *BitSet {
+	if delta >= 0 {
+		b.ShiftLeft(uint(delta))
+	} else {
+		// -(delta+1) does not overflow when delta is the minimal int
+		b.ShiftRight(uint(-(delta + 1)) + 1)
+	}
+	return b
+}
+
+// resizeTo sets the length of the BitSet to exactly width bits,
+// growing the backing slice if needed or clearing the bits that
+// are dropped when the BitSet becomes shorter.
+func (b *BitSet) resizeTo(width uint) {
+	b.prepareWrite()
+	if width > b.length {
+		b.extendSet(width - 1)
+		return
+	}
+	n := wordsNeeded(width)
+	// zero the dropped words, since extendSet may reslice into them later
+	for i := n; i < len(b.set); i++ {
+		b.set[i] = 0
+	}
+	b.set = b.set[:n]
+	b.length = width
+	if n > 0 {
+		b.cleanLastWord()
+	}
+}
+
+// ShiftLeftBounded shifts the bitset like << operation would do, but
+// within a fixed width, like a hardware shift register: after the call,
+// the length of the BitSet is exactly width and the bits that would be
+// shifted at or beyond width are discarded.
+func (b *BitSet) ShiftLeftBounded(bits, width uint) *BitSet {
+	panicIfNull(b)
+
+	b.resizeTo(width)
+	if bits == 0 {
+		return b
+	}
+	if bits >= width {
+		return b.ClearAll()
+	}
+
+	n := len(b.set)
+	shift, pages := bits%wordSize, int(bits>>log2WordSize)
+	// we go from the top word down, so that the source words are read
+	// before they are overwritten
+	for i := n - 1; i >= 0; i-- {
+		var v uint64
+		if src := i - pages; src >= 0 {
+			v = b.set[src] << shift
+			if shift != 0 && src > 0 {
+				v |= b.set[src-1] >> (wordSize - shift)
+			}
+		}
+		b.set[i] = v
+	}
+	b.cleanLastWord()
+	return b
+}
+
+// ShiftLeftWithCarry shifts the bitset like << operation would do, within
+// its current length (see ShiftLeftBounded), and returns the bits that were
+// shifted out as a new BitSet of length bits.
+//
+// The result is defined as if the carry were a register of width bits sitting
+// above the BitSet: bit k of the carry is the bit at index Len()-bits+k
+// before the shift, or 0 when that index is negative. Thus when bits <= Len(),
+// the carry holds the top bits of the BitSet; when bits > Len(), the whole
+// content of the BitSet is found in the carry, starting at index bits-Len().
+// This allows to chain shifts across several fixed-width BitSets.
+func (b *BitSet) ShiftLeftWithCarry(bits uint) *BitSet {
+	panicIfNull(b)
+
+	carry := New(bits)
+	offset := int(b.length) - int(bits)
+	for j := range carry.set {
+		start := offset + j*wordSize
+		switch {
+		case start <= -wordSize:
+			// entirely below bit 0
+		case start < 0:
+			carry.set[j] = b.GetWord64AtBit(0) << uint(-start)
+		default:
+			carry.set[j] = b.GetWord64AtBit(uint(start))
+		}
+	}
+	carry.cleanLastWord()
+
+	b.ShiftLeftBounded(bits, b.length)
+	return carry
+}
+
 // ShiftRight shifts the bitset like >> operation would do.
 func (b *BitSet) ShiftRight(bits uint) {
 	panicIfNull(b)
+	b.prepareWrite()
 
 	if bits == 0 {
 		return
@@ -1505,7 +3675,7 @@ func (b *BitSet) ShiftRight(bits uint) {
 		return
 	}
 
-	if bits >= top {
+	if bits > top {
 		b.set = make([]uint64, wordsNeeded(b.length))
 		return
 	}
@@ -1534,6 +3704,69 @@ func (b *BitSet) ShiftRight(bits uint) {
 	}
 }
 
+// ReverseRange reverses the order of the bits in [start, end), in place:
+// bit start+k moves to end-1-k. The other bits are unchanged. It panics if
+// start > end or end > Len(). It returns the BitSet.
+func (b *BitSet) ReverseRange(start, end uint) *BitSet {
+	if start > end || end > b.length {
+		panic("BitSet.ReverseRange: range out of bounds")
+	}
+	n := end - start
+	if n < 2 {
+		return b
+	}
+	b.prepareWrite()
+	// copy the range, aligned on a word boundary
+	tmp := make([]uint64, wordsNeeded(n))
+	for k := range tmp {
+		tmp[k] = b.GetWord64AtBit(start + uint(k)<<log2WordSize)
+	}
+	// reverse the len(tmp)*64 bits, then drop the extra low bits, which
+	// were beyond the range before the reversal
+	for k, l := 0, len(tmp)-1; k <= l; k, l = k+1, l-1 {
+		tmp[k], tmp[l] = bits.Reverse64(tmp[l]), bits.Reverse64(tmp[k])
+	}
+	if s := uint(len(tmp))<<log2WordSize - n; s != 0 {
+		for k := range tmp {
+			tmp[k] = tmp[k]>>s | wordAt(tmp, k+1)<<(wordSize-s)
+		}
+	}
+	for k, w := range tmp {
+		width := n - uint(k)<<log2WordSize
+		if width > wordSize {
+			width = wordSize
+		}
+		b.putBits(start+uint(k)<<log2WordSize, w&(allBits>>(wordSize-width)), width)
+	}
+	return b
+}
+
+// Reverse reverses the order of the bits below Len(), in place: bit i
+// moves to Len()-1-i. It is the same as ReverseRange(0, Len()). It returns
+// the BitSet.
+func (b *BitSet) Reverse() *BitSet {
+	return b.ReverseRange(0, b.length)
+}
+
+// RotateLeft rotates the bits below Len() by k positions towards the higher
+// indexes, in place: bit i moves to (i+k) mod Len(), so that the bits that
+// ShiftLeftBounded(k, Len()) would discard wrap around to the lowest
+// indexes. It does not change the length. It returns the BitSet.
+//
+// It uses three reversals: rotating by k is the same as reversing the
+// whole BitSet, then reversing [0, k) and [k, Len()) separately.
+func (b *BitSet) RotateLeft(k uint) *BitSet {
+	n := b.length
+	if n == 0 {
+		return b
+	}
+	k %= n
+	if k == 0 {
+		return b
+	}
+	return b.Reverse().ReverseRange(0, k).ReverseRange(k, n)
+}
+
 // OnesBetween returns the number of set bits in the range [from, to).
 // The range is inclusive of 'from' and exclusive of 'to'.
 // Returns 0 if from >= to.
@@ -1578,6 +3811,24 @@ func (b *BitSet) OnesBetween(from, to uint) uint {
 	return count
 }
 
+// CountBitsInWords returns the number of set bits in the 64-bit words
+// with indexes in [wordStart, wordEnd), see Words. The indexes are clamped
+// to the words needed to store Len() bits. When the range is aligned on
+// words, it is equivalent to OnesBetween(wordStart*64, wordEnd*64).
+func (b *BitSet) CountBitsInWords(wordStart, wordEnd int) uint {
+	words := b.usedWords()
+	if wordStart < 0 {
+		wordStart = 0
+	}
+	if wordEnd > len(words) {
+		wordEnd = len(words)
+	}
+	if wordStart >= wordEnd {
+		return 0
+	}
+	return uint(popcntSlice(words[wordStart:wordEnd]))
+}
+
 // Extract extracts bits according to a mask and returns the result
 // in a new BitSet. See ExtractTo for details.
 func (b *BitSet) Extract(mask *BitSet) *BitSet {
@@ -1597,6 +3848,7 @@ func (b *BitSet) ExtractTo(mask *BitSet, dst *BitSet) {
 	panicIfNull(b)
 	panicIfNull(mask)
 	panicIfNull(dst)
+	dst.prepareWrite()
 
 	if len(mask.set) == 0 || len(b.set) == 0 {
 		return
@@ -1656,6 +3908,7 @@ func (b *BitSet) DepositTo(mask *BitSet, dst *BitSet) {
 	panicIfNull(b)
 	panicIfNull(mask)
 	panicIfNull(dst)
+	dst.prepareWrite()
 
 	if len(dst.set) == 0 || len(mask.set) == 0 || len(b.set) == 0 {
 		return
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"math/bits"
 	"math/rand"
+	"runtime"
 	"testing"
 )
 
@@ -59,6 +60,19 @@ func BenchmarkCount(b *testing.B) {
 	}
 }
 
+// go test -bench=Parity
+func BenchmarkParity(b *testing.B) {
+	b.StopTimer()
+	s := New(100000)
+	for i := 0; i < 100000; i += 100 {
+		s.Set(uint(i))
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		s.Parity()
+	}
+}
+
 // go test -bench=Iterate
 func BenchmarkIterate(b *testing.B) {
 	b.StopTimer()
@@ -75,6 +89,23 @@ func BenchmarkIterate(b *testing.B) {
 	}
 }
 
+// go test -bench=IterateIterator
+func BenchmarkIterateIterator(b *testing.B) {
+	b.StopTimer()
+	s := New(10000)
+	for i := 0; i < 10000; i += 3 {
+		s.Set(uint(i))
+	}
+	b.StartTimer()
+	for j := 0; j < b.N; j++ {
+		c := uint(0)
+		it := s.Iterator()
+		for _, e := it.Next(); e; _, e = it.Next() {
+			c++
+		}
+	}
+}
+
 // go test -bench=SparseIterate
 func BenchmarkSparseIterate(b *testing.B) {
 	b.StopTimer()
@@ -217,6 +248,22 @@ func BenchmarkLemireCount(b *testing.B) {
 	}
 }
 
+// go test -bench=LemireCountParallel
+func BenchmarkLemireCountParallel(b *testing.B) {
+	bitmap := New(100000000)
+	for v := uint(0); v <= 100000000; v += 100 {
+		bitmap.Set(v)
+	}
+	b.ResetTimer()
+	sum := uint(0)
+	for i := 0; i < b.N; i++ {
+		sum += bitmap.CountParallel(runtime.NumCPU())
+	}
+	if sum == 0 { // added just to fool ineffassign
+		return
+	}
+}
+
 // go test -bench=LemireIterate
 // see https://lemire.me/blog/2016/09/22/swift-versus-java-the-bitset-performance-test/
 func BenchmarkLemireIterate(b *testing.B) {
@@ -658,3 +705,13 @@ func BenchmarkClearRMS(b *testing.B) {
 		}
 	})
 }
+
+// go test -bench=ClearAll
+func BenchmarkClearAll(b *testing.B) {
+	s := New(1 << 24)
+	b.SetBytes(int64(len(s.set) * wordBytes))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ClearAll()
+	}
+}
@@ -0,0 +1,210 @@
+//go:build go1.23
+// +build go1.23
+
+package bitset
+
+import (
+	"iter"
+	"math/bits"
+)
+
+// EachSet returns an iterator over the set bits of the BitSet, in
+// increasing order. It does not allocate.
+//
+//	for i := range b.EachSet() {
+//	 do something with i
+//	}
+//
+// The BitSet should not be modified while it is being iterated.
+func (b *BitSet) EachSet() iter.Seq[uint] {
+	return func(yield func(uint) bool) {
+		for idx, word := range b.set {
+			for word != 0 {
+				if !yield(uint(idx<<log2WordSize + bits.TrailingZeros64(word))) {
+					return
+				}
+				// clear the rightmost set bit
+				word &= word - 1
+			}
+		}
+	}
+}
+
+// Values returns an iterator over the set bits of the BitSet, that is,
+// over the values in the set, in increasing order. It is the same as EachSet.
+func (b *BitSet) Values() iter.Seq[uint] {
+	return b.EachSet()
+}
+
+// EachSetBatch returns an iterator over the set bits of the BitSet, in
+// increasing order, yielding them in successive slices of batchSize
+// indexes (the last slice may be shorter). It uses NextSetMany.
+//
+// The same backing buffer is reused for every slice: a slice is only valid
+// until the next iteration, and it must be copied to be retained.
+// The BitSet should not be modified while it is being iterated.
+// The function panics if batchSize is not positive.
+func (b *BitSet) EachSetBatch(batchSize int) iter.Seq[[]uint] {
+	if batchSize <= 0 {
+		panic("BitSet.EachSetBatch: batchSize must be positive")
+	}
+	return func(yield func([]uint) bool) {
+		buffer := make([]uint, batchSize)
+		var i uint
+		for {
+			j, batch := b.NextSetMany(i, buffer)
+			if len(batch) == 0 || !yield(batch) {
+				return
+			}
+			if len(batch) < batchSize {
+				return // no more set bits
+			}
+			i = j + 1
+		}
+	}
+}
+
+// EachSetAlignedBlock returns an iterator over the aligned blocks of
+// blockBits bits of the BitSet which contain at least one set bit, in
+// increasing order. For each such block, it yields the index base of its
+// first bit, a multiple of blockBits, and a BitSet of length blockBits
+// whose bit j is the bit base+j of the BitSet. Empty blocks are skipped,
+// so that a large sparse set can be processed block by block.
+//
+// The same BitSet is reused for every block: it is only valid until the
+// next iteration, and it must be cloned to be retained.
+// The BitSet should not be modified while it is being iterated.
+// The function panics if blockBits is 0.
+func (b *BitSet) EachSetAlignedBlock(blockBits uint) iter.Seq2[uint, *BitSet] {
+	if blockBits == 0 {
+		panic("BitSet.EachSetAlignedBlock: blockBits must be positive")
+	}
+	return func(yield func(uint, *BitSet) bool) {
+		block := New(blockBits)
+		for i := uint(0); ; {
+			j, ok := b.NextSet(i)
+			if !ok {
+				return
+			}
+			base := j - j%blockBits
+			block.prepareWrite()
+			for k := range block.set {
+				block.set[k] = b.GetWord64AtBit(base + uint(k)<<log2WordSize)
+			}
+			block.cleanLastWord()
+			if !yield(base, block) {
+				return
+			}
+			i = base + blockBits
+			if i < base {
+				return // the next block would exceed the capacity
+			}
+		}
+	}
+}
+
+// EachClear returns an iterator over the clear bits of the BitSet below
+// Len(), in increasing order.
+// The BitSet should not be modified while it is being iterated.
+func (b *BitSet) EachClear() iter.Seq[uint] {
+	return func(yield func(uint) bool) {
+		for i := uint(0); ; i++ {
+			j, ok := b.NextClear(i)
+			if !ok || !yield(j) {
+				return
+			}
+			i = j
+		}
+	}
+}
+
+// EachRange returns an iterator over the maximal runs of set bits of the
+// BitSet, in increasing order, yielding each run as the interval
+// [start, end) of its indexes.
+// The BitSet should not be modified while it is being iterated.
+func (b *BitSet) EachRange() iter.Seq2[uint, uint] {
+	return func(yield func(uint, uint) bool) {
+		for i := uint(0); i < b.length; {
+			start, ok := b.NextSet(i)
+			if !ok {
+				return
+			}
+			end, ok := b.NextClear(start)
+			if !ok {
+				end = b.length
+			}
+			if !yield(start, end) {
+				return
+			}
+			i = end
+		}
+	}
+}
+
+// EachClearRange returns an iterator over the maximal runs of clear bits
+// of the BitSet below Len(), in increasing order, yielding each run as
+// the interval [start, end) of its indexes: these are the free intervals
+// of an allocator. The last run ends at Len() when the last bit is clear,
+// and a BitSet without set bits is a single run [0, Len()), unless it is
+// of length 0. Together, EachRange and EachClearRange partition [0, Len()).
+// The BitSet should not be modified while it is being iterated.
+func (b *BitSet) EachClearRange() iter.Seq2[uint, uint] {
+	return func(yield func(uint, uint) bool) {
+		for i := uint(0); i < b.length; {
+			start, ok := b.NextClear(i)
+			if !ok {
+				return
+			}
+			end, ok := b.NextSet(start)
+			if !ok {
+				end = b.length
+			}
+			if !yield(start, end) {
+				return
+			}
+			i = end
+		}
+	}
+}
+
+// EachCommon returns an iterator over the indexes set in both b and other,
+// in increasing order, computed a word at a time without building the
+// intersection. The BitSets may have different lengths.
+// Neither BitSet should be modified while it is being iterated.
+func (b *BitSet) EachCommon(other *BitSet) iter.Seq[uint] {
+	return func(yield func(uint) bool) {
+		aw, bw := b.usedWords(), other.usedWords()
+		n := len(aw)
+		if len(bw) < n {
+			n = len(bw)
+		}
+		for idx := 0; idx < n; idx++ {
+			for word := aw[idx] & bw[idx]; word != 0; word &= word - 1 {
+				if !yield(uint(idx<<log2WordSize + bits.TrailingZeros64(word))) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// EachEither returns an iterator over the indexes set in b or in other,
+// in increasing order, computed a word at a time without building the
+// union. The BitSets may have different lengths.
+// Neither BitSet should be modified while it is being iterated.
+func (b *BitSet) EachEither(other *BitSet) iter.Seq[uint] {
+	return func(yield func(uint) bool) {
+		aw, bw := b.usedWords(), other.usedWords()
+		n := len(aw)
+		if len(bw) > n {
+			n = len(bw)
+		}
+		for idx := 0; idx < n; idx++ {
+			for word := wordAt(aw, idx) | wordAt(bw, idx); word != 0; word &= word - 1 {
+				if !yield(uint(idx<<log2WordSize + bits.TrailingZeros64(word))) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,300 @@
+//go:build go1.23
+// +build go1.23
+
+package bitset
+
+import (
+	"iter"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestEachSet(t *testing.T) {
+	b := New(300)
+	b.Set(0).Set(5).Set(63).Set(64).Set(200).Set(299)
+	var got []uint
+	for i := range b.EachSet() {
+		got = append(got, i)
+	}
+	if !reflect.DeepEqual(got, b.AppendTo(nil)) {
+		t.Errorf("expected %v, got %v", b.AppendTo(nil), got)
+	}
+
+	// early exit
+	got = got[:0]
+	for i := range b.EachSet() {
+		if i > 63 {
+			break
+		}
+		got = append(got, i)
+	}
+	if !reflect.DeepEqual(got, []uint{0, 5, 63}) {
+		t.Errorf("unexpected values before break: %v", got)
+	}
+
+	for range new(BitSet).EachSet() {
+		t.Error("the zero value should have no set bits")
+	}
+}
+
+func TestValues(t *testing.T) {
+	b := New(1000)
+	for i := uint(0); i < 1000; i += 7 {
+		b.Set(i)
+	}
+	var got []uint
+	for i := range b.Values() {
+		got = append(got, i)
+	}
+	if !reflect.DeepEqual(got, b.AppendTo(nil)) {
+		t.Errorf("expected %v, got %v", b.AppendTo(nil), got)
+	}
+	for k := 1; k < len(got); k++ {
+		if got[k-1] >= got[k] {
+			t.Fatalf("values are not sorted: %v", got)
+		}
+	}
+
+	var sum uint
+	allocs := testing.AllocsPerRun(100, func() {
+		for i := range b.Values() {
+			sum += i
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocation, got %v", allocs)
+	}
+}
+
+func TestEachSetBatch(t *testing.T) {
+	b := New(1000)
+	for i := uint(0); i < 1000; i += 7 {
+		b.Set(i)
+	}
+	b.Set(999)
+	for _, size := range []int{1, 2, 3, 64, 144, 145, 1000} {
+		var got []uint
+		batches := 0
+		for batch := range b.EachSetBatch(size) {
+			if len(batch) > size || len(batch) == 0 {
+				t.Fatalf("size %d: unexpected batch length %d", size, len(batch))
+			}
+			got = append(got, batch...)
+			batches++
+		}
+		if !reflect.DeepEqual(got, b.AsSlice(make([]uint, b.Count()))) {
+			t.Errorf("size %d: expected %v, got %v", size, b.AppendTo(nil), got)
+		}
+		if expected := (len(got) + size - 1) / size; batches != expected {
+			t.Errorf("size %d: expected %d batches, got %d", size, expected, batches)
+		}
+	}
+
+	// the final partial batch
+	var last []uint
+	for batch := range New(100).Set(1).Set(50).Set(99).EachSetBatch(2) {
+		last = append(last[:0], batch...)
+	}
+	if !reflect.DeepEqual(last, []uint{99}) {
+		t.Errorf("unexpected last batch: %v", last)
+	}
+
+	for range new(BitSet).EachSetBatch(4) {
+		t.Error("the zero value should have no set bits")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("a batch size of 0 should panic")
+		}
+	}()
+	b.EachSetBatch(0)
+}
+
+func TestEachSetAlignedBlock(t *testing.T) {
+	r := rand.New(rand.NewSource(10))
+	b := New(5000)
+	// a sparse set with a few dense areas
+	for i := 0; i < 100; i++ {
+		b.Set(uint(r.Intn(5000)))
+	}
+	b.FlipRange(1000, 1300)
+	b.Set(4999)
+	for _, blockBits := range []uint{1, 7, 64, 100, 128, 1000, 6000} {
+		rebuilt := New(b.Len())
+		var prev uint
+		blocks := 0
+		for base, block := range b.EachSetAlignedBlock(blockBits) {
+			if base%blockBits != 0 || (blocks > 0 && base <= prev) {
+				t.Fatalf("block bits %d: unexpected base %d after %d", blockBits, base, prev)
+			}
+			if block.Len() != blockBits || block.None() {
+				t.Fatalf("block bits %d: unexpected block %v at %d", blockBits, block, base)
+			}
+			if err := block.CheckInvariants(); err != nil {
+				t.Fatal(err)
+			}
+			for j := range block.EachSet() {
+				rebuilt.Set(base + j)
+			}
+			prev = base
+			blocks++
+		}
+		if !rebuilt.Equal(b) {
+			t.Errorf("block bits %d: the blocks do not reconstruct the set", blockBits)
+		}
+		nonEmpty := 0
+		for base := uint(0); base < b.Len(); base += blockBits {
+			end := base + blockBits
+			if end > b.Len() {
+				end = b.Len()
+			}
+			if next, ok := b.NextSet(base); ok && next < end {
+				nonEmpty++
+			}
+		}
+		if blocks != nonEmpty {
+			t.Errorf("block bits %d: expected %d blocks, got %d", blockBits, nonEmpty, blocks)
+		}
+	}
+
+	for range new(BitSet).EachSetAlignedBlock(64) {
+		t.Error("the zero value should have no set bits")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("a block size of 0 should panic")
+		}
+	}()
+	b.EachSetAlignedBlock(0)
+}
+
+func TestEachClearRange(t *testing.T) {
+	type interval struct{ start, end uint }
+	r := rand.New(rand.NewSource(11))
+	for _, length := range []uint{0, 1, 63, 64, 65, 200, 1000} {
+		for trial := 0; trial < 10; trial++ {
+			b := New(length)
+			density := trial%4 + 1
+			for i := uint(0); i < length; i++ {
+				if r.Intn(density) != 0 {
+					b.Set(i)
+				}
+			}
+			switch trial {
+			case 0:
+				b.ClearAll()
+			case 1:
+				b.SetAll()
+			}
+
+			// coalesce the clear bits into runs
+			var expected []interval
+			for i := range b.EachClear() {
+				if i >= length || b.Test(i) {
+					t.Fatalf("%d is not a clear bit", i)
+				}
+				if k := len(expected) - 1; k >= 0 && expected[k].end == i {
+					expected[k].end++
+				} else {
+					expected = append(expected, interval{i, i + 1})
+				}
+			}
+			var clear []interval
+			for start, end := range b.EachClearRange() {
+				clear = append(clear, interval{start, end})
+			}
+			if !reflect.DeepEqual(clear, expected) {
+				t.Fatalf("length %d: expected %v, got %v", length, expected, clear)
+			}
+
+			// together with the runs of set bits, the runs of clear bits partition [0, length)
+			var all []interval
+			for start, end := range b.EachRange() {
+				for i := start; i < end; i++ {
+					if !b.Test(i) {
+						t.Fatalf("%d is not a set bit", i)
+					}
+				}
+				all = append(all, interval{start, end})
+			}
+			all = append(all, clear...)
+			sort.Slice(all, func(i, j int) bool { return all[i].start < all[j].start })
+			var next uint
+			for _, in := range all {
+				if in.start != next || in.end <= in.start {
+					t.Fatalf("length %d: intervals do not partition: %v", length, all)
+				}
+				next = in.end
+			}
+			if next != length {
+				t.Fatalf("length %d: intervals end at %d", length, next)
+			}
+		}
+	}
+
+	b := New(100)
+	for start, end := range b.EachClearRange() {
+		if start != 0 || end != 100 {
+			t.Errorf("expected [0, 100), got [%d, %d)", start, end)
+		}
+	}
+	b.Set(10).Set(99)
+	for start := range b.EachClearRange() {
+		if start != 0 {
+			t.Error("expected early exit")
+		}
+		break
+	}
+}
+
+func TestEachCommonEither(t *testing.T) {
+	r := rand.New(rand.NewSource(12))
+	collect := func(seq iter.Seq[uint]) []uint {
+		var values []uint
+		for i := range seq {
+			values = append(values, i)
+		}
+		return values
+	}
+	for _, lengths := range [][2]uint{{0, 0}, {0, 100}, {100, 100}, {70, 300}, {1000, 65}} {
+		a, b := New(lengths[0]), New(lengths[1])
+		for i := uint(0); i < lengths[0]; i++ {
+			if r.Intn(3) == 0 {
+				a.Set(i)
+			}
+		}
+		for i := uint(0); i < lengths[1]; i++ {
+			if r.Intn(3) == 0 {
+				b.Set(i)
+			}
+		}
+		if got, expected := collect(a.EachCommon(b)), collect(a.Intersection(b).EachSet()); !reflect.DeepEqual(got, expected) {
+			t.Errorf("lengths %v: EachCommon = %v; expected %v", lengths, got, expected)
+		}
+		if got, expected := collect(b.EachCommon(a)), collect(a.Intersection(b).EachSet()); !reflect.DeepEqual(got, expected) {
+			t.Errorf("lengths %v: EachCommon = %v; expected %v", lengths, got, expected)
+		}
+		if got, expected := collect(a.EachEither(b)), collect(a.Union(b).EachSet()); !reflect.DeepEqual(got, expected) {
+			t.Errorf("lengths %v: EachEither = %v; expected %v", lengths, got, expected)
+		}
+	}
+
+	// early exit
+	a, b := New(200).Set(1).Set(100).Set(150), New(200).Set(100).Set(150).Set(199)
+	for i := range a.EachCommon(b) {
+		if i != 100 {
+			t.Errorf("unexpected value %d", i)
+		}
+		break
+	}
+	for i := range a.EachEither(b) {
+		if i != 1 {
+			t.Errorf("unexpected value %d", i)
+		}
+		break
+	}
+}
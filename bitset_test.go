@@ -21,8 +21,11 @@ import (
 	"math/rand"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func TestStringer(t *testing.T) {
@@ -35,6 +38,55 @@ func TestStringer(t *testing.T) {
 	}
 }
 
+func TestFormat(t *testing.T) {
+	b := New(70)
+	b.Set(1).Set(2).Set(3).Set(10).Set(64)
+	dump := b.DumpAsBits()
+	tests := []struct {
+		format, expected string
+	}{
+		{"%b", dump},
+		{"%+b", dump + " (len=70, count=5)"},
+		{"%#b", "0000010000000000000000000000000000000000000000000000000000010000001110"},
+		{"%.10b", dump[:7] + "..."},
+		{"%.2b", dump[:2]},
+		{"%.1000b", dump},
+		{"%#75b", "     0000010000000000000000000000000000000000000000000000000000010000001110"},
+		{"%-#72b|", "0000010000000000000000000000000000000000000000000000000000010000001110  |"},
+		// the other verbs behave as for any Stringer
+		{"%v", "{1,2,3,10,64}"},
+		{"%s", "{1,2,3,10,64}"},
+		{"%+v", "{1,2,3,10,64}"},
+		{"%q", `"{1,2,3,10,64}"`},
+		{"%x", fmt.Sprintf("%x", "{1,2,3,10,64}")},
+		{"%.5v", "{1,2,"},
+		{"%15v", "  {1,2,3,10,64}"},
+		{"%-15s|", "{1,2,3,10,64}  |"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprintf(test.format, b); got != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.format, test.expected, got)
+		}
+	}
+
+	// %#v prints the Go syntax of the BitSet
+	if got, prefix := fmt.Sprintf("%#v", b), "&bitset.BitSet{length:0x46, set:[]uint64{0x40e, 0x1}"; !strings.HasPrefix(got, prefix) {
+		t.Errorf("%%#v: expected a prefix %q, got %q", prefix, got)
+	}
+	var nilSet *BitSet
+	if got := fmt.Sprintf("%#v %v", nilSet, nilSet); got != "(*bitset.BitSet)(nil) <nil>" {
+		t.Errorf("unexpected output for a nil BitSet: %q", got)
+	}
+	if got := fmt.Sprintf("%d", b); strings.Contains(got, "%!") {
+		t.Errorf("%%d: unexpected output %q", got)
+	}
+
+	empty := New(3)
+	if got := fmt.Sprintf("%v %#b %b", empty, empty, New(0)); got != "{} 000 " {
+		t.Errorf("unexpected output for an empty set: %q", got)
+	}
+}
+
 func TestStringLong(t *testing.T) {
 	v := New(0)
 	for i := uint(0); i < 262145; i++ {
@@ -84,6 +136,75 @@ func TestBitSetHuge(t *testing.T) {
 	}
 }
 
+func TestFromChan(t *testing.T) {
+	ch := make(chan uint)
+	close(ch)
+	if b := FromChan(ch); b.Len() != 0 || b.Any() {
+		t.Errorf("expected an empty set, got %v of length %d", b, b.Len())
+	}
+
+	values := []uint{5, 1000, 5, 64, 3, 1000, 64, 200}
+	ch = make(chan uint)
+	go func() {
+		for _, v := range values {
+			ch <- v
+		}
+		close(ch)
+	}()
+	b := FromChan(ch)
+	expected := New(1001).Set(3).Set(5).Set(64).Set(200).Set(1000)
+	if !b.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, b)
+	}
+	if cap(b.set) != b.wordCount() {
+		t.Errorf("expected a capacity of %d words, got %d", b.wordCount(), cap(b.set))
+	}
+}
+
+func TestMergeSorted(t *testing.T) {
+	streams := [][]uint{
+		{1, 2, 3, 100},
+		{2, 3, 4, 64, 64, 65},
+		{},
+		{500},
+		{0, 7, 100, 128},
+	}
+	b := MergeSorted(streams...)
+	expected := New(0)
+	for _, s := range streams {
+		c := New(0)
+		for _, i := range s {
+			c.Set(i)
+		}
+		expected.InPlaceUnion(c)
+	}
+	if !b.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, b)
+	}
+	if b.Len() != 501 {
+		t.Errorf("expected length 501, got %d", b.Len())
+	}
+	// disjoint streams
+	b = MergeSorted([]uint{0, 2, 4}, []uint{1, 3, 5})
+	if b.Count() != 6 || !b.All() {
+		t.Errorf("unexpected result %v", b)
+	}
+	if b := MergeSorted(); b.Len() != 0 || b.Any() {
+		t.Error("no stream should give an empty set")
+	}
+	// unsorted streams are rejected
+	for _, s := range [][]uint{{10, 3}, {1, 1000, 2}} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%v: expected a panic for an unsorted stream", s)
+				}
+			}()
+			MergeSorted([]uint{0, 5}, s)
+		}()
+	}
+}
+
 func TestLen(t *testing.T) {
 	v := New(1000)
 	if v.Len() != 1000 {
@@ -155,6 +276,31 @@ func TestExceedCap(t *testing.T) {
 	bmp.Set(d)
 }
 
+func TestExceedCapNearLimit(t *testing.T) {
+	mustPanic := func(name string, f func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s should have caused a panic", name)
+			}
+		}()
+		f()
+	}
+	b := New(100).Set(10)
+	// growing to the last valid index cannot be allocated, but it must
+	// fail cleanly rather than allocating a small slice
+	mustPanic("Set(Cap()-1)", func() { b.Set(Cap() - 1) })
+	mustPanic("Set(Cap())", func() { b.Set(Cap()) })
+	mustPanic("ShiftLeft", func() { b.ShiftLeft(Cap() - 10) })
+	mustPanic("ShiftLeft", func() { b.ShiftLeft(Cap() - 11) })
+	if b.Len() != 100 || b.Count() != 1 || !b.Test(10) {
+		t.Errorf("the BitSet should be unchanged: length %d, count %d", b.Len(), b.Count())
+	}
+	full := New(64).SetAll()
+	mustPanic("AllocClearN", func() { full.AllocClearN(Cap(), nil) })
+	mustPanic("AllocClearN", func() { full.AllocClearN(Cap()-63, nil) })
+}
+
 func TestExpand(t *testing.T) {
 	v := New(0)
 	defer func() {
@@ -175,6 +321,44 @@ func TestBitSetAndGet(t *testing.T) {
 	}
 }
 
+func TestAllocClearN(t *testing.T) {
+	b := New(200)
+	b.FlipRange(0, 130).Clear(5).Clear(64).Clear(100)
+	got := b.AllocClearN(4, []uint{1000})
+	if !reflect.DeepEqual(got, []uint{1000, 5, 64, 100, 130}) {
+		t.Errorf("unexpected indexes %v", got)
+	}
+	for _, i := range got[1:] {
+		if !b.Test(i) {
+			t.Errorf("bit %d should be set", i)
+		}
+	}
+	if b.Len() != 200 || b.Count() != 131 {
+		t.Errorf("unexpected length %d or count %d", b.Len(), b.Count())
+	}
+
+	// not enough clear bits: the BitSet grows
+	c := New(10).FlipRange(0, 10).Clear(7)
+	got = c.AllocClearN(3, nil)
+	if !reflect.DeepEqual(got, []uint{7, 10, 11}) {
+		t.Errorf("unexpected indexes %v", got)
+	}
+	if c.Len() != 12 || !c.All() {
+		t.Errorf("unexpected result %v of length %d", c, c.Len())
+	}
+
+	d := new(BitSet)
+	if got := d.AllocClearN(70, nil); len(got) != 70 || got[69] != 69 || d.Count() != 70 {
+		t.Errorf("unexpected result %v", got)
+	}
+	if got := d.AllocClearN(0, nil); got != nil {
+		t.Errorf("expected no index, got %v", got)
+	}
+	if err := d.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestNextClear(t *testing.T) {
 	v := New(1000)
 	v.Set(0).Set(1)
@@ -388,6 +572,109 @@ func TestNextSet(t *testing.T) {
 	}
 }
 
+func TestNextSetCyclic(t *testing.T) {
+	b := New(300).Set(10).Set(64).Set(250)
+	for _, tc := range []struct{ start, expected uint }{
+		{0, 10}, {10, 10}, {11, 64}, {65, 250}, {250, 250}, {251, 10}, {299, 10}, {1000, 10},
+	} {
+		if i, ok := b.NextSetCyclic(tc.start); !ok || i != tc.expected {
+			t.Errorf("NextSetCyclic(%d) = %d, %v; expected %d", tc.start, i, ok, tc.expected)
+		}
+	}
+
+	// round-robin over all the set bits
+	var visited []uint
+	for i, k := uint(0), 0; k < 6; k++ {
+		next, _ := b.NextSetCyclic(i)
+		visited = append(visited, next)
+		i = next + 1
+	}
+	if !reflect.DeepEqual(visited, []uint{10, 64, 250, 10, 64, 250}) {
+		t.Errorf("unexpected round-robin order: %v", visited)
+	}
+
+	for _, empty := range []*BitSet{New(0), New(100), {}} {
+		if _, ok := empty.NextSetCyclic(5); ok {
+			t.Error("an empty BitSet has no set bit")
+		}
+	}
+}
+
+func TestIterator(t *testing.T) {
+	for _, length := range []uint{0, 1, 63, 64, 65, 1000} {
+		b := New(length)
+		for i := uint(0); i < length; i += 7 {
+			b.Set(i)
+		}
+		if length > 0 {
+			b.Set(length - 1)
+		}
+		var got []uint
+		it := b.Iterator()
+		for i, ok := it.Next(); ok; i, ok = it.Next() {
+			got = append(got, i)
+		}
+		if !reflect.DeepEqual(got, b.AppendTo(nil)) {
+			t.Errorf("length %d: expected %v, got %v", length, b.AppendTo(nil), got)
+		}
+		if _, ok := it.Next(); ok {
+			t.Error("an exhausted iterator should stay exhausted")
+		}
+	}
+
+	var empty BitSet
+	it := empty.Iterator()
+	if _, ok := it.Next(); ok {
+		t.Error("the zero value should have no set bits")
+	}
+}
+
+func TestNextSetN(t *testing.T) {
+	b := New(1000)
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 200; i++ {
+		b.Set(uint(r.Intn(1000)))
+	}
+	values := b.AppendTo(nil)
+	for k := uint(0); k < uint(len(values)); k++ {
+		i, ok := b.NextSetN(0, k)
+		if !ok || i != b.Select(k) {
+			t.Errorf("NextSetN(0, %d) = %d, %v; expected %d", k, i, ok, b.Select(k))
+		}
+	}
+	for _, start := range []uint{0, 1, 30, 63, 64, 65, 500, 999} {
+		var after []uint
+		for _, v := range values {
+			if v >= start {
+				after = append(after, v)
+			}
+		}
+		for k := uint(0); k <= uint(len(after)); k++ {
+			i, ok := b.NextSetN(start, k)
+			if k == uint(len(after)) {
+				if ok {
+					t.Errorf("NextSetN(%d, %d) should not find a bit, got %d", start, k, i)
+				}
+				continue
+			}
+			if !ok || i != after[k] {
+				t.Errorf("NextSetN(%d, %d) = %d, %v; expected %d", start, k, i, ok, after[k])
+			}
+		}
+		if i, ok := b.NextSet(start); ok {
+			if j, _ := b.NextSetN(start, 0); j != i {
+				t.Errorf("NextSetN(%d, 0) should match NextSet", start)
+			}
+		}
+	}
+	if _, ok := b.NextSetN(2000, 0); ok {
+		t.Error("no bit should be found beyond the length")
+	}
+	if _, ok := new(BitSet).NextSetN(0, 0); ok {
+		t.Error("the zero value should have no set bits")
+	}
+}
+
 func TestNextSetMany(t *testing.T) {
 	testCases := []struct {
 		name string
@@ -617,6 +904,114 @@ func TestSetTo(t *testing.T) {
 	}
 }
 
+func TestSetIf(t *testing.T) {
+	b := New(100)
+	if b.SetIf(10, true) != b || !b.Test(10) {
+		t.Error("SetIf(10, true) should set bit 10")
+	}
+	b.SetIf(20, false).SetIf(10, false)
+	if b.Test(20) || !b.Test(10) {
+		t.Error("SetIf(i, false) should leave the bit unchanged")
+	}
+
+	const big = 1 << 30
+	allocs := testing.AllocsPerRun(10, func() {
+		b.SetIf(big, false)
+	})
+	if allocs != 0 || b.Len() != 100 {
+		t.Errorf("SetIf(big, false) should not grow the BitSet: %v allocations, length %d", allocs, b.Len())
+	}
+	b.SetIf(big, true)
+	if b.Len() != big+1 || !b.Test(big) {
+		t.Errorf("SetIf(big, true) should grow the BitSet to %d, got %d", big+1, b.Len())
+	}
+}
+
+func TestSetStrict(t *testing.T) {
+	b := New(100)
+	for _, i := range []uint{0, 63, 64, 99} {
+		if err := b.SetStrict(i); err != nil {
+			t.Errorf("SetStrict(%d) should succeed: %v", i, err)
+		}
+		if !b.Test(i) {
+			t.Errorf("bit %d should be set", i)
+		}
+	}
+	for _, i := range []uint{100, 128, 1000} {
+		err := b.SetStrict(i)
+		if !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("SetStrict(%d) should fail with ErrOutOfRange, got %v", i, err)
+		}
+	}
+	if b.Len() != 100 || b.Count() != 4 {
+		t.Errorf("SetStrict should never grow the set: len %d, count %d", b.Len(), b.Count())
+	}
+	if err := new(BitSet).SetStrict(0); err == nil {
+		t.Error("SetStrict on an empty set should fail")
+	}
+}
+
+func TestSetMaskAt(t *testing.T) {
+	r := rand.New(rand.NewSource(14))
+	for trial := 0; trial < 200; trial++ {
+		offset := uint(r.Intn(300))
+		mask := r.Uint64() >> uint(r.Intn(64))
+		b := New(uint(r.Intn(200)))
+		b.SetMaskAt(offset, mask)
+		expected := New(b.Len())
+		for k := uint(0); k < 64; k++ {
+			if mask&(1<<k) != 0 {
+				expected.Set(offset + k)
+			}
+		}
+		if !b.Equal(expected) {
+			t.Fatalf("offset %d, mask %x: expected %v, got %v", offset, mask, expected, b)
+		}
+		if err := b.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// across a word boundary, growing exactly to the highest bit
+	b := New(0).SetMaskAt(60, 0x1f1)
+	if b.Len() != 69 || !b.Equal(New(69).Set(60).Set(64).Set(65).Set(66).Set(67).Set(68)) {
+		t.Errorf("unexpected result %v of length %d", b, b.Len())
+	}
+	if New(10).SetMaskAt(1000, 0).Len() != 10 {
+		t.Error("a zero mask should not grow the BitSet")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("SetMaskAt beyond the capacity should panic")
+		}
+	}()
+	New(10).SetMaskAt(Cap()-3, 0xf0)
+}
+
+func TestSetIntTestInt(t *testing.T) {
+	b := New(100)
+	for _, i := range []int{0, 63, 64, 150} {
+		if err := b.SetInt(i); err != nil {
+			t.Errorf("SetInt(%d) should succeed: %v", i, err)
+		}
+		if !b.TestInt(i) || !b.Test(uint(i)) {
+			t.Errorf("bit %d should be set", i)
+		}
+	}
+	for _, i := range []int{-1, -64, -1 << (strconv.IntSize - 1)} {
+		err := b.SetInt(i)
+		if !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("SetInt(%d) should fail with ErrOutOfRange, got %v", i, err)
+		}
+		if b.TestInt(i) {
+			t.Errorf("TestInt(%d) should be false", i)
+		}
+	}
+	if b.Len() != 151 || b.Count() != 4 {
+		t.Errorf("unexpected length %d or count %d", b.Len(), b.Count())
+	}
+}
+
 func TestChain(t *testing.T) {
 	if !New(1000).Set(100).Set(99).Clear(99).Test(100) {
 		t.Errorf("Bit %d is clear, and it shouldn't be.", 100)
@@ -664,6 +1059,169 @@ func TestCount(t *testing.T) {
 	}
 }
 
+func TestParity(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	for _, length := range []uint{0, 1, 63, 64, 65, 1000, 10000} {
+		for trial := 0; trial < 10; trial++ {
+			b := New(length)
+			for k := 0; k < r.Intn(int(length)+1); k++ {
+				b.Set(uint(r.Intn(int(length))))
+			}
+			if got, expected := b.Parity(), b.Count()%2 == 1; got != expected {
+				t.Errorf("length %d: parity %v, expected %v for count %d", length, got, expected, b.Count())
+			}
+		}
+	}
+	c := NewCounted(100).Set(3).Set(70).Set(99)
+	if !c.Parity() || c.Clear(70).Parity() {
+		t.Error("unexpected parity for a counted BitSet")
+	}
+	var nilSet *BitSet
+	if nilSet.Parity() || new(BitSet).Parity() {
+		t.Error("an empty BitSet has an even parity")
+	}
+}
+
+func TestCountAdjacentPairs(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	for _, length := range []uint{0, 1, 2, 63, 64, 65, 200, 1000} {
+		for trial := 0; trial < 10; trial++ {
+			b := New(length)
+			for i := uint(0); i < length; i++ {
+				if r.Intn(3) != 0 {
+					b.Set(i)
+				}
+			}
+			var expected uint
+			for i := uint(0); i+1 < length; i++ {
+				if b.Test(i) && b.Test(i+1) {
+					expected++
+				}
+			}
+			if got := b.CountAdjacentPairs(); got != expected {
+				t.Errorf("length %d: expected %d pairs, got %d", length, expected, got)
+			}
+		}
+	}
+
+	// pairs across word boundaries
+	b := New(300).Set(63).Set(64).Set(127).Set(128).Set(129).Set(191).Set(256)
+	if got := b.CountAdjacentPairs(); got != 3 {
+		t.Errorf("expected 3 pairs, got %d", got)
+	}
+}
+
+func TestLongestSetRun(t *testing.T) {
+	naive := func(b *BitSet) (start, length uint) {
+		for i := uint(0); i < b.Len(); {
+			if !b.Test(i) {
+				i++
+				continue
+			}
+			j := i
+			for j < b.Len() && b.Test(j) {
+				j++
+			}
+			if j-i > length {
+				start, length = i, j-i
+			}
+			i = j
+		}
+		return start, length
+	}
+	r := rand.New(rand.NewSource(8))
+	for _, length := range []uint{0, 1, 63, 64, 65, 200, 1000} {
+		for trial := 0; trial < 20; trial++ {
+			b := New(length)
+			// long runs are more likely with a high density
+			density := 1 + r.Intn(40)
+			for i := uint(0); i < length; i++ {
+				if r.Intn(density+1) != 0 {
+					b.Set(i)
+				}
+			}
+			es, el := naive(b)
+			if s, l := b.LongestSetRun(); s != es || l != el {
+				t.Errorf("length %d: expected (%d, %d), got (%d, %d)", length, es, el, s, l)
+			}
+		}
+	}
+
+	// runs across word boundaries and full words
+	b := New(400).FlipRange(10, 20).FlipRange(60, 200).FlipRange(250, 390)
+	if s, l := b.LongestSetRun(); s != 60 || l != 140 {
+		t.Errorf("expected (60, 140), got (%d, %d)", s, l)
+	}
+	if s, l := New(100).LongestSetRun(); s != 0 || l != 0 {
+		t.Errorf("expected (0, 0), got (%d, %d)", s, l)
+	}
+	if s, l := New(128).SetAll().LongestSetRun(); s != 0 || l != 128 {
+		t.Errorf("expected (0, 128), got (%d, %d)", s, l)
+	}
+}
+
+func TestWordHistogram(t *testing.T) {
+	b := New(640)
+	b.FlipRange(64, 256)  // words 1 to 3 full
+	b.Set(300).Set(400)   // words 4 and 6 mixed
+	b.FlipRange(576, 640) // word 9 full
+	b.FlipRange(500, 520) // words 7 and 8 mixed
+	zeros, ones, mixed := b.WordHistogram()
+	if zeros != 2 || ones != 4 || mixed != 4 {
+		t.Errorf("expected (2, 4, 4), got (%d, %d, %d)", zeros, ones, mixed)
+	}
+
+	// the last word is incomplete
+	c := New(100).SetAll()
+	if zeros, ones, mixed := c.WordHistogram(); zeros != 0 || ones != 1 || mixed != 1 {
+		t.Errorf("expected (0, 1, 1), got (%d, %d, %d)", zeros, ones, mixed)
+	}
+
+	if zeros, ones, mixed := new(BitSet).WordHistogram(); zeros+ones+mixed != 0 {
+		t.Error("the zero value has no word")
+	}
+}
+
+func TestRunCount(t *testing.T) {
+	naive := func(b *BitSet) uint {
+		var runs uint
+		values := b.AppendTo(nil)
+		for k, v := range values {
+			if k == 0 || values[k-1] != v-1 {
+				runs++
+			}
+		}
+		return runs
+	}
+	r := rand.New(rand.NewSource(6))
+	for _, length := range []uint{0, 1, 63, 64, 65, 200, 1000} {
+		for trial := 0; trial < 10; trial++ {
+			b := New(length)
+			for i := uint(0); i < length; i++ {
+				if r.Intn(3) != 0 {
+					b.Set(i)
+				}
+			}
+			if got := b.RunCount(); got != naive(b) {
+				t.Errorf("length %d: expected %d runs, got %d", length, naive(b), got)
+			}
+		}
+	}
+
+	// runs spanning words
+	b := New(300)
+	b.FlipRange(60, 70).FlipRange(127, 129).FlipRange(191, 257).Set(299)
+	if got := b.RunCount(); got != 4 {
+		t.Errorf("expected 4 runs, got %d", got)
+	}
+	if got := New(128).SetAll().RunCount(); got != 1 {
+		t.Errorf("expected 1 run for a full set, got %d", got)
+	}
+	if got := new(BitSet).RunCount(); got != 0 {
+		t.Errorf("expected no run for the zero value, got %d", got)
+	}
+}
+
 // test setting every 3rd bit, just in case something odd is happening
 func TestCount2(t *testing.T) {
 	tot := uint(64*4 + 11) // just some multi unit64 number
@@ -678,6 +1236,62 @@ func TestCount2(t *testing.T) {
 	}
 }
 
+func TestCountApprox(t *testing.T) {
+	r := rand.New(rand.NewSource(20))
+	for _, density := range []int{2, 4, 10} {
+		b := New(1 << 22)
+		for i := uint(0); i < b.Len(); i++ {
+			if r.Intn(density) == 0 {
+				b.Set(i)
+			}
+		}
+		count := float64(b.Count())
+		estimate := float64(b.CountApprox(10000))
+		// 8*W*sqrt(p*(1-p)/k) is below 0.5% of the count for these densities,
+		// we allow for 5 standard deviations
+		if math.Abs(estimate-count) > 0.025*count {
+			t.Errorf("density 1/%d: estimate %v too far from %v", density, estimate, count)
+		}
+	}
+
+	b := New(1000).Set(1).Set(500).Set(999)
+	if b.CountApprox(16) != 3 || b.CountApprox(100) != 3 {
+		t.Error("sampling all the words should give the exact count")
+	}
+	if b.CountApprox(0) != 0 || new(BitSet).CountApprox(10) != 0 {
+		t.Error("expected 0")
+	}
+}
+
+func TestCountMatchingWords(t *testing.T) {
+	b := From([]uint64{0xff, 0x1ff, 0xff, 0xf0f, 0, allBits})
+	if c := b.CountMatchingWords(allBits, 0xff); c != 2 {
+		t.Errorf("expected 2 words equal to 0xff, got %d", c)
+	}
+	if c := b.CountMatchingWords(allBits, 0); c != 1 {
+		t.Errorf("expected 1 empty word, got %d", c)
+	}
+	// low byte equal to 0xff
+	if c := b.CountMatchingWords(0xff, 0xff); c != 4 {
+		t.Errorf("expected 4 words with a full low byte, got %d", c)
+	}
+	// second nibble equal to 0
+	if c := b.CountMatchingWords(0xf0, 0); c != 2 {
+		t.Errorf("expected 2 words with a clear second nibble, got %d", c)
+	}
+	// a pattern that is not within the mask never matches
+	if c := b.CountMatchingWords(0xf, 0x10); c != 0 {
+		t.Errorf("expected no match, got %d", c)
+	}
+	// only the words needed for the length are considered
+	if c := FromWithLength(64, []uint64{0, 0, 0}).CountMatchingWords(allBits, 0); c != 1 {
+		t.Errorf("expected 1 word, got %d", c)
+	}
+	if c := new(BitSet).CountMatchingWords(0, 0); c != 0 {
+		t.Errorf("expected no word, got %d", c)
+	}
+}
+
 // nil tests
 func TestNullTest(t *testing.T) {
 	var v *BitSet
@@ -689,6 +1303,67 @@ func TestNullTest(t *testing.T) {
 	v.Test(66)
 }
 
+func TestTestMany(t *testing.T) {
+	b := New(200)
+	for i := uint(0); i < 200; i += 3 {
+		b.Set(i)
+	}
+	indices := []uint{0, 1, 3, 199, 198, 200, 1000, 63, 64, 66}
+	for j := uint(0); j < 100; j++ {
+		indices = append(indices, j*7)
+	}
+	check := func(out *BitSet) {
+		if out.Len() != uint(len(indices)) {
+			t.Errorf("expected length %d, got %d", len(indices), out.Len())
+		}
+		for j, i := range indices {
+			if out.Test(uint(j)) != b.Test(i) {
+				t.Errorf("bit %d should be %v (index %d)", j, b.Test(i), i)
+			}
+		}
+	}
+	check(b.TestMany(indices, nil))
+
+	// out is cleared and reused
+	out := New(1000).SetAll()
+	if b.TestMany(indices, out) != out {
+		t.Error("TestMany should return out")
+	}
+	check(out)
+	if err := out.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	out = New(0)
+	check(b.TestMany(indices, out))
+
+	if b.TestMany(nil, out).Len() != 0 {
+		t.Error("no index should give an empty result")
+	}
+
+	// out may not be the BitSet itself
+	c := New(10).Set(3).Set(5)
+	if got := c.TestMany([]uint{3, 4, 5}, c); got == c || !got.Equal(New(3).Set(0).Set(2)) || !c.Equal(New(10).Set(3).Set(5)) {
+		t.Errorf("unexpected result %v for %v", got, c)
+	}
+}
+
+func TestNullSafeTest(t *testing.T) {
+	var v *BitSet
+	if v.SafeTest(66) || v.SafeTest(0) {
+		t.Error("a nil BitSet should have no set bits")
+	}
+	if v.SafeCount() != 0 {
+		t.Error("a nil BitSet should have a count of 0")
+	}
+	v = New(100).Set(66)
+	if !v.SafeTest(66) || v.SafeTest(67) || v.SafeTest(1000) {
+		t.Error("SafeTest should match Test on a non-nil BitSet")
+	}
+	if v.SafeCount() != 1 {
+		t.Errorf("expected a count of 1, got %d", v.SafeCount())
+	}
+}
+
 func TestNullSet(t *testing.T) {
 	var v *BitSet
 	defer func() {
@@ -722,6 +1397,30 @@ func TestNullCount(t *testing.T) {
 	}
 }
 
+func TestNewWithHint(t *testing.T) {
+	for _, maxIndex := range []uint{0, 63, 64, 1000} {
+		b := NewWithHint(maxIndex, 10)
+		if b.Len() != 0 || b.Any() {
+			t.Errorf("expected an empty BitSet, got %v of length %d", b, b.Len())
+		}
+		allocs := testing.AllocsPerRun(10, func() {
+			for i := uint(0); i <= maxIndex; i += 7 {
+				b.Set(i)
+			}
+			b.Set(maxIndex)
+		})
+		if allocs != 0 {
+			t.Errorf("maxIndex %d: expected no allocation, got %v", maxIndex, allocs)
+		}
+		if b.Len() != maxIndex+1 || !b.Test(maxIndex) {
+			t.Errorf("maxIndex %d: unexpected length %d", maxIndex, b.Len())
+		}
+		if err := b.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
 func TestMustNew(t *testing.T) {
 	testCases := []struct {
 		length uint
@@ -920,6 +1619,39 @@ func TestAll(t *testing.T) {
 	}
 }
 
+func TestAllPartialLastWord(t *testing.T) {
+	for _, length := range []uint{1, 2, 63, 64, 65, 127, 128, 129, 200} {
+		b := New(length)
+		b.FlipRange(0, length)
+		if !b.All() || !b.IsFull() {
+			t.Errorf("length %d: all the bits are set", length)
+		}
+		for _, i := range []uint{0, 63, 64, length - 1} {
+			if i >= length {
+				continue
+			}
+			c := b.Clone().Clear(i)
+			if c.All() || c.IsFull() {
+				t.Errorf("length %d: bit %d is clear", length, i)
+			}
+		}
+		if b.IsEmpty() || !New(length).IsEmpty() {
+			t.Errorf("length %d: IsEmpty should be the same as None", length)
+		}
+
+		// the capacity beyond the length does not matter
+		d := NewWithHint(length+200, 0)
+		d.FlipRange(0, length)
+		if !d.All() {
+			t.Errorf("length %d: all the bits are set", length)
+		}
+	}
+	var empty BitSet
+	if !empty.IsFull() || !empty.IsEmpty() {
+		t.Error("an empty set is both full and empty")
+	}
+}
+
 func TestShrink(t *testing.T) {
 	bs := New(10)
 	bs.Set(0)
@@ -1069,6 +1801,35 @@ func TestShrink(t *testing.T) {
 	}
 }
 
+func TestCompactReport(t *testing.T) {
+	for _, tc := range []struct {
+		length uint
+		bits   []uint
+		freed  int
+	}{
+		{1000, []uint{1, 100}, 14},
+		{1000, []uint{999}, 0},
+		{1000, nil, 15},
+		{64, []uint{3}, 0},
+		{0, nil, 0},
+	} {
+		b := New(tc.length)
+		for _, i := range tc.bits {
+			b.Set(i)
+		}
+		before := len(b.set)
+		freed := b.CompactReport()
+		if freed != tc.freed || freed != before-len(b.set) {
+			t.Errorf("length %d, bits %v: expected %d freed words, got %d (%d -> %d)", tc.length, tc.bits, tc.freed, freed, before, len(b.set))
+		}
+		for _, i := range tc.bits {
+			if !b.Test(i) {
+				t.Errorf("bit %d was lost", i)
+			}
+		}
+	}
+}
+
 func TestInsertAtWithSet(t *testing.T) {
 	b := New(0)
 	b.Set(0)
@@ -1236,12 +1997,45 @@ func TestNone(t *testing.T) {
 	}
 }
 
-func TestEqual(t *testing.T) {
-	a := New(100)
-	b := New(99)
-	c := New(100)
-	if a.Equal(b) {
-		t.Error("Sets of different sizes should be not be equal")
+func TestFirstDifference(t *testing.T) {
+	base := New(300).Set(1).Set(64).Set(200)
+	for _, tc := range []struct {
+		other    *BitSet
+		expected uint
+		ok       bool
+	}{
+		{New(300).Set(1).Set(64).Set(200), 0, false},
+		{New(300).Set(1).Set(64), 200, true},
+		{New(300).Set(0).Set(1).Set(64).Set(200), 0, true},
+		{New(300).Set(1).Set(63).Set(64).Set(200), 63, true},
+		{New(300).Set(1).Set(64).Set(200).Set(299), 299, true},
+		{New(100).Set(1).Set(64), 200, true},          // shorter, differs beyond its length
+		{New(201).Set(1).Set(64).Set(200), 201, true}, // same bits, shorter
+		{New(500).Set(1).Set(64).Set(200), 300, true}, // same bits, longer
+		{New(500).Set(1).Set(64).Set(200).Set(450), 450, true},
+	} {
+		i, ok := base.FirstDifference(tc.other)
+		if i != tc.expected || ok != tc.ok {
+			t.Errorf("FirstDifference(%v) = %d, %v; expected %d, %v", tc.other, i, ok, tc.expected, tc.ok)
+		}
+		if ok != !base.Equal(tc.other) {
+			t.Errorf("FirstDifference(%v) disagrees with Equal", tc.other)
+		}
+		if j, ok2 := tc.other.FirstDifference(base); j != i || ok2 != ok {
+			t.Errorf("FirstDifference should be symmetric")
+		}
+	}
+	if _, ok := new(BitSet).FirstDifference(New(0)); ok {
+		t.Error("empty BitSets are equal")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a := New(100)
+	b := New(99)
+	c := New(100)
+	if a.Equal(b) {
+		t.Error("Sets of different sizes should be not be equal")
 	}
 	if !a.Equal(c) {
 		t.Error("Two empty sets of the same size should be equal")
@@ -1327,6 +2121,194 @@ func TestInPlaceUnion(t *testing.T) {
 	}
 }
 
+func TestSelect3(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	random := func(length uint) *BitSet {
+		b := New(length)
+		for i := uint(0); i < length; i++ {
+			if r.Intn(2) == 0 {
+				b.Set(i)
+			}
+		}
+		return b
+	}
+	for _, lengths := range [][3]uint{{0, 0, 0}, {100, 100, 100}, {10, 200, 70}, {300, 64, 130}, {65, 130, 1}} {
+		cond, a, b := random(lengths[0]), random(lengths[1]), random(lengths[2])
+		result := Select3(cond, a, b)
+		length := a.Len()
+		if b.Len() > length {
+			length = b.Len()
+		}
+		if result.Len() != length {
+			t.Errorf("expected length %d, got %d", length, result.Len())
+		}
+		for i := uint(0); i < length; i++ {
+			expected := b.Test(i)
+			if cond.Test(i) {
+				expected = a.Test(i)
+			}
+			if result.Test(i) != expected {
+				t.Errorf("lengths %v: bit %d should be %v", lengths, i, expected)
+			}
+		}
+		if err := result.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestUnionLenIntersectionLen(t *testing.T) {
+	var zero BitSet
+	for _, tc := range []struct {
+		a, b                *BitSet
+		union, intersection uint
+	}{
+		{&zero, &zero, 0, 0},
+		{&zero, New(100), 100, 0},
+		{New(100), &zero, 100, 0},
+		{New(64), New(65), 65, 64},
+		{New(1000).Set(5), New(10).Set(2000), 2001, 1000},
+	} {
+		if got := tc.a.UnionLen(tc.b); got != tc.union {
+			t.Errorf("UnionLen(%d, %d) = %d, expected %d", tc.a.Len(), tc.b.Len(), got, tc.union)
+		}
+		if got := tc.a.IntersectionLen(tc.b); got != tc.intersection {
+			t.Errorf("IntersectionLen(%d, %d) = %d, expected %d", tc.a.Len(), tc.b.Len(), got, tc.intersection)
+		}
+		// the lengths match those of the computed operations
+		if got := tc.a.Union(tc.b).Len(); got != tc.union {
+			t.Errorf("Union length %d, expected %d", got, tc.union)
+		}
+		if got := IntersectionInto(new(BitSet), tc.a, tc.b); got != tc.intersection {
+			t.Errorf("IntersectionInto length %d, expected %d", got, tc.intersection)
+		}
+	}
+}
+
+func TestUnionInto(t *testing.T) {
+	a := New(100)
+	b := New(200)
+	for i := uint(1); i < 100; i += 2 {
+		a.Set(i)
+		b.Set(i - 1)
+	}
+	for i := uint(100); i < 200; i++ {
+		b.Set(i)
+	}
+	expected := a.Union(b)
+
+	dst := New(10).Set(5)
+	if l := UnionInto(dst, a, b); l != 200 {
+		t.Errorf("expected a length of 200, got %d", l)
+	}
+	if !dst.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, dst)
+	}
+	// a larger destination is shrunk
+	dst = New(1000).Set(999)
+	UnionInto(dst, b, a)
+	if !dst.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, dst)
+	}
+
+	// aliasing
+	c, d := a.Clone(), b.Clone()
+	UnionInto(c, c, d)
+	if !c.Equal(expected) || !d.Equal(b) {
+		t.Errorf("aliasing the first operand failed: %v", c)
+	}
+	c, d = a.Clone(), b.Clone()
+	UnionInto(d, c, d)
+	if !d.Equal(expected) || !c.Equal(a) {
+		t.Errorf("aliasing the second operand failed: %v", d)
+	}
+	c = a.Clone()
+	UnionInto(c, c, c)
+	if !c.Equal(a) {
+		t.Errorf("the union of a set with itself should be the set: %v", c)
+	}
+
+	// empty operands
+	dst = New(0)
+	if l := UnionInto(dst, new(BitSet), new(BitSet)); l != 0 || dst.Any() {
+		t.Error("the union of empty sets should be empty")
+	}
+
+	// no allocation when the destination is large enough
+	dst = New(200)
+	allocs := testing.AllocsPerRun(100, func() {
+		UnionInto(dst, a, b)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocation, got %v", allocs)
+	}
+}
+
+func TestOperationsInto(t *testing.T) {
+	type op struct {
+		name      string
+		into      func(dst, a, b *BitSet) uint
+		allocated func(a, b *BitSet) *BitSet
+	}
+	ops := []op{
+		{"Union", UnionInto, (*BitSet).Union},
+		{"Intersection", IntersectionInto, (*BitSet).Intersection},
+		{"Difference", DifferenceInto, (*BitSet).Difference},
+		{"SymmetricDifference", SymmetricDifferenceInto, (*BitSet).SymmetricDifference},
+	}
+	r := rand.New(rand.NewSource(42))
+	random := func(length uint) *BitSet {
+		b := New(length)
+		for i := uint(0); i < length; i++ {
+			if r.Intn(2) == 0 {
+				b.Set(i)
+			}
+		}
+		return b
+	}
+	for _, o := range ops {
+		for _, la := range []uint{0, 10, 64, 100, 200} {
+			for _, lb := range []uint{0, 10, 64, 100, 200} {
+				a, b := random(la), random(lb)
+				expected := o.allocated(a, b)
+
+				dst := New(5).Set(1)
+				if l := o.into(dst, a, b); l != expected.Len() {
+					t.Errorf("%s(%d, %d): expected length %d, got %d", o.name, la, lb, expected.Len(), l)
+				}
+				if !dst.Equal(expected) {
+					t.Errorf("%s(%d, %d): expected %v, got %v", o.name, la, lb, expected, dst)
+				}
+
+				// aliasing
+				c, d := a.Clone(), b.Clone()
+				o.into(c, c, d)
+				if !c.Equal(expected) || !d.Equal(b) {
+					t.Errorf("%s(%d, %d): aliasing the first operand failed", o.name, la, lb)
+				}
+				c, d = a.Clone(), b.Clone()
+				o.into(d, c, d)
+				if !d.Equal(expected) || !c.Equal(a) {
+					t.Errorf("%s(%d, %d): aliasing the second operand failed", o.name, la, lb)
+				}
+				if err := dst.CheckInvariants(); err != nil {
+					t.Errorf("%s(%d, %d): %v", o.name, la, lb, err)
+				}
+			}
+		}
+
+		// no allocation when the destination is presized
+		a, b := random(150), random(300)
+		dst := New(300)
+		allocs := testing.AllocsPerRun(100, func() {
+			o.into(dst, a, b)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: expected no allocation, got %v", o.name, allocs)
+		}
+	}
+}
+
 func TestIntersection(t *testing.T) {
 	a := New(100)
 	b := New(200)
@@ -1353,6 +2335,28 @@ func TestIntersection(t *testing.T) {
 	}
 }
 
+func TestCountAt(t *testing.T) {
+	b := New(200).Set(1).Set(64).Set(100).Set(199)
+	positions := New(300).Set(1).Set(2).Set(100).Set(199).Set(250)
+	if got := b.CountAt(positions); got != 3 {
+		t.Errorf("expected 3 of the positions to be set, got %d", got)
+	}
+
+	// length mismatch: the positions beyond the shorter length are clear
+	if got := b.CountAt(New(65).Set(1).Set(64)); got != 2 {
+		t.Errorf("expected 2, got %d", got)
+	}
+	if got := New(65).Set(1).Set(64).CountAt(b); got != 2 {
+		t.Errorf("expected 2, got %d", got)
+	}
+	if got := b.CountAt(New(0)); got != 0 {
+		t.Errorf("expected 0, got %d", got)
+	}
+	if b.CountAt(positions) != b.IntersectionCardinality(positions) {
+		t.Error("CountAt should agree with IntersectionCardinality")
+	}
+}
+
 func TestInplaceIntersection(t *testing.T) {
 	a := New(100)
 	b := New(200)
@@ -1378,6 +2382,63 @@ func TestInplaceIntersection(t *testing.T) {
 	}
 }
 
+func TestDiffRanges(t *testing.T) {
+	before := New(300)
+	before.FlipRange(10, 20).FlipRange(60, 130).Set(200)
+	after := New(400)
+	after.FlipRange(15, 25).FlipRange(60, 70).FlipRange(128, 192).Set(350)
+	added, removed := after.DiffRanges(before)
+	if expected := [][2]uint{{20, 25}, {130, 192}, {350, 351}}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected added %v, got %v", expected, added)
+	}
+	if expected := [][2]uint{{10, 15}, {70, 128}, {200, 201}}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected removed %v, got %v", expected, removed)
+	}
+	added2, removed2 := before.DiffRanges(after)
+	if !reflect.DeepEqual(added2, removed) || !reflect.DeepEqual(removed2, added) {
+		t.Error("DiffRanges should be antisymmetric")
+	}
+
+	// a run covering whole words
+	full := New(256).FlipRange(0, 256)
+	added, removed = full.DiffRanges(New(0))
+	if !reflect.DeepEqual(added, [][2]uint{{0, 256}}) || removed != nil {
+		t.Errorf("unexpected ranges %v, %v", added, removed)
+	}
+
+	// random sets, checked against the symmetric difference
+	r := rand.New(rand.NewSource(17))
+	a, b := New(500), New(450)
+	for i := uint(0); i < 500; i++ {
+		if r.Intn(3) == 0 {
+			a.Set(i)
+		}
+		if i < 450 && r.Intn(3) == 0 {
+			b.Set(i)
+		}
+	}
+	added, removed = a.DiffRanges(b)
+	diff := New(500)
+	for _, ranges := range [][][2]uint{added, removed} {
+		var last uint
+		for k, rg := range ranges {
+			if rg[0] >= rg[1] || (k > 0 && rg[0] <= last) {
+				t.Fatalf("ranges should be sorted, non-empty and maximal: %v", ranges)
+			}
+			last = rg[1]
+			for i := rg[0]; i < rg[1]; i++ {
+				if a.Test(i) == b.Test(i) || diff.Test(i) {
+					t.Fatalf("unexpected bit %d in ranges", i)
+				}
+				diff.Set(i)
+			}
+		}
+	}
+	if !diff.Equal(a.SymmetricDifference(b)) {
+		t.Error("the ranges should cover the symmetric difference")
+	}
+}
+
 func TestDifference(t *testing.T) {
 	a := New(100)
 	b := New(200)
@@ -1433,6 +2494,39 @@ func TestInPlaceDifference(t *testing.T) {
 	}
 }
 
+func TestRemoveSet(t *testing.T) {
+	a := New(100)
+	b := New(200)
+	for i := uint(0); i < 100; i += 3 {
+		a.Set(i)
+	}
+	for i := uint(0); i < 200; i += 2 {
+		b.Set(i)
+	}
+	expected := a.Clone()
+	expected.InPlaceDifference(b)
+
+	c := a.Clone()
+	if c.RemoveSet(b) != c {
+		t.Error("RemoveSet should return the receiver")
+	}
+	if !c.Equal(expected) {
+		t.Errorf("RemoveSet should match InPlaceDifference: %v != %v", c, expected)
+	}
+
+	d := a.Clone()
+	removed := d.RemoveSetCount(b)
+	if removed != a.IntersectionCardinality(b) {
+		t.Errorf("RemoveSetCount should return %d, got %d", a.IntersectionCardinality(b), removed)
+	}
+	if !d.Equal(expected) {
+		t.Errorf("RemoveSetCount should match InPlaceDifference: %v != %v", d, expected)
+	}
+	if d.Count() != a.Count()-removed {
+		t.Errorf("expected %d bits left, got %d", a.Count()-removed, d.Count())
+	}
+}
+
 func TestSymmetricDifference(t *testing.T) {
 	a := New(100)
 	b := New(200)
@@ -1463,6 +2557,62 @@ func TestSymmetricDifference(t *testing.T) {
 	}
 }
 
+func TestParityCardinality(t *testing.T) {
+	r := rand.New(rand.NewSource(15))
+	if ParityCardinality() != 0 {
+		t.Error("the parity of no BitSet should be empty")
+	}
+	for _, lengths := range [][]uint{{100}, {100, 100}, {10, 300, 64}, {1000, 65, 500, 0, 128}} {
+		sets := make([]*BitSet, len(lengths))
+		expected := New(0)
+		for k, length := range lengths {
+			sets[k] = New(length)
+			for i := uint(0); i < length; i++ {
+				if r.Intn(2) == 0 {
+					sets[k].Set(i)
+				}
+			}
+			expected = expected.SymmetricDifference(sets[k])
+		}
+		if got := ParityCardinality(sets...); got != expected.Count() {
+			t.Errorf("lengths %v: expected %d, got %d", lengths, expected.Count(), got)
+		}
+	}
+	a := New(100).Set(1).Set(2)
+	if got := ParityCardinality(a, a, a); got != 2 {
+		t.Errorf("expected 2, got %d", got)
+	}
+}
+
+func TestSymmetricDifferenceCardinalityRange(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	a, b := New(200), New(300)
+	for i := 0; i < 150; i++ {
+		a.Set(uint(r.Intn(200)))
+		b.Set(uint(r.Intn(300)))
+	}
+	bounds := []uint{0, 1, 10, 63, 64, 65, 128, 199, 200, 201, 299, 300, 500}
+	for _, start := range bounds {
+		for _, end := range bounds {
+			var expected uint
+			for i := start; i < end; i++ {
+				if a.Test(i) != b.Test(i) {
+					expected++
+				}
+			}
+			if got := a.SymmetricDifferenceCardinalityRange(b, start, end); got != expected {
+				t.Errorf("[%d, %d): expected %d, got %d", start, end, expected, got)
+			}
+			if got := b.SymmetricDifferenceCardinalityRange(a, start, end); got != expected {
+				t.Errorf("[%d, %d) reversed: expected %d, got %d", start, end, expected, got)
+			}
+		}
+	}
+	if a.SymmetricDifferenceCardinalityRange(b, 0, 1000) != a.SymmetricDifferenceCardinality(b) {
+		t.Error("the full range should match SymmetricDifferenceCardinality")
+	}
+}
+
 func TestInPlaceSymmetricDifference(t *testing.T) {
 	a := New(100)
 	b := New(200)
@@ -1488,6 +2638,80 @@ func TestInPlaceSymmetricDifference(t *testing.T) {
 	}
 }
 
+func TestCheckInvariants(t *testing.T) {
+	b := New(100)
+	b.Set(3).Set(99).Set(150)
+	if err := b.CheckInvariants(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := new(BitSet).CheckInvariants(); err != nil {
+		t.Errorf("unexpected error for the zero value: %v", err)
+	}
+	var null *BitSet
+	if null.CheckInvariants() == nil {
+		t.Error("a nil BitSet should be reported")
+	}
+
+	// corrupt the last word
+	c := New(100)
+	c.Words()[1] |= 1 << 40
+	if c.CheckInvariants() == nil {
+		t.Error("a bit set beyond the length should be reported")
+	}
+
+	// corrupt a word beyond the length
+	if FromWithLength(64, []uint64{1, 1}).CheckInvariants() == nil {
+		t.Error("a bit set in a word beyond the length should be reported")
+	}
+	if err := FromWithLength(64, []uint64{1, 0}).CheckInvariants(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// a backing slice that is too short
+	d := &BitSet{length: 200, set: make([]uint64, 2)}
+	if d.CheckInvariants() == nil {
+		t.Error("a short backing slice should be reported")
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	// junk above the length, in the last word and in an extra word
+	b := FromWithLength(70, []uint64{allBits, 0xff00000000000001, 0x1234})
+	if b.CheckInvariants() == nil {
+		t.Fatal("the invariants should be broken")
+	}
+	expected := New(70).FlipRange(0, 64).Set(64)
+	if b.Canonicalize() != b {
+		t.Error("Canonicalize should return the receiver")
+	}
+	if err := b.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	if b.Len() != 70 || b.Count() != 65 || !b.Equal(expected) {
+		t.Errorf("unexpected result %v", b)
+	}
+	// growing the set must not bring back the extra word
+	b.Set(200)
+	if b.Count() != 66 || b.Test(128) {
+		t.Errorf("unexpected result after growth %v", b)
+	}
+
+	// a canonical set is unchanged
+	c := New(128).Set(3).Set(127)
+	c.Canonicalize()
+	if c.Len() != 128 || c.Count() != 2 {
+		t.Errorf("unexpected result %v", c)
+	}
+	new(BitSet).Canonicalize()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Canonicalize should panic on a short slice")
+		}
+	}()
+	(&BitSet{length: 200, set: make([]uint64, 2)}).Canonicalize()
+}
+
 func TestComplement(t *testing.T) {
 	a := New(50)
 	b := a.Complement()
@@ -1502,6 +2726,105 @@ func TestComplement(t *testing.T) {
 	}
 }
 
+func TestRelativeComplement(t *testing.T) {
+	universe := New(200)
+	for i := uint(0); i < 150; i++ {
+		universe.Set(i)
+	}
+	for _, length := range []uint{0, 100, 200, 300} {
+		b := New(length)
+		for i := uint(0); i < length; i += 3 {
+			b.Set(i)
+		}
+		result := b.RelativeComplement(universe)
+		if !result.Equal(universe.Difference(b)) {
+			t.Errorf("length %d: expected %v, got %v", length, universe.Difference(b), result)
+		}
+		if result.Len() != universe.Len() {
+			t.Errorf("length %d: the result should have the length of the universe, got %d", length, result.Len())
+		}
+		if result.IntersectionCardinality(b) != 0 || !universe.IsSuperSet(result) {
+			t.Errorf("length %d: unexpected result %v", length, result)
+		}
+	}
+}
+
+func TestGray(t *testing.T) {
+	// the Gray codes of 0 to 7
+	for v, g := range []uint64{0, 1, 3, 2, 6, 7, 5, 4} {
+		b := FromWithLength(3, []uint64{uint64(v)})
+		if got := b.ToGray(); got.set[0] != g || got.Len() != 3 {
+			t.Errorf("ToGray(%d) = %v; expected %d", v, got, g)
+		}
+		if got := FromWithLength(3, []uint64{g}).FromGray(); got.set[0] != uint64(v) {
+			t.Errorf("FromGray(%d) = %v; expected %d", g, got, v)
+		}
+	}
+
+	r := rand.New(rand.NewSource(19))
+	for _, length := range []uint{0, 1, 63, 64, 65, 130, 1000} {
+		for trial := 0; trial < 5; trial++ {
+			b := New(length)
+			for i := uint(0); i < length; i++ {
+				if r.Intn(2) == 0 {
+					b.Set(i)
+				}
+			}
+			g := b.ToGray()
+			for i := uint(0); i < length; i++ {
+				if expected := b.Test(i) != b.Test(i+1); g.Test(i) != expected {
+					t.Fatalf("length %d: bit %d of the Gray code should be %v", length, i, expected)
+				}
+			}
+			if back := g.FromGray(); !back.Equal(b) {
+				t.Fatalf("length %d: FromGray(ToGray(x)) != x", length)
+			}
+			if back := b.FromGray().ToGray(); !back.Equal(b) {
+				t.Fatalf("length %d: ToGray(FromGray(x)) != x", length)
+			}
+			if err := b.FromGray().CheckInvariants(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+func TestPrefixXor(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	for _, length := range []uint{0, 1, 63, 64, 65, 200, 1000} {
+		b := New(length)
+		for i := uint(0); i < length; i++ {
+			if r.Intn(2) == 0 {
+				b.Set(i)
+			}
+		}
+		expected := New(length)
+		parity := false
+		for i := uint(0); i < length; i++ {
+			parity = parity != b.Test(i)
+			expected.SetTo(i, parity)
+		}
+		got := b.PrefixXor()
+		if !got.Equal(expected) {
+			t.Errorf("length %d: expected %v, got %v", length, expected, got)
+		}
+		if err := got.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// applying it twice is not the identity in general
+	b := New(10).Set(0)
+	twice := b.PrefixXor().PrefixXor()
+	if twice.Equal(b) {
+		t.Error("PrefixXor applied twice should differ from the identity here")
+	}
+	// {0} -> {0,...,9} -> {0,2,4,6,8}
+	if !reflect.DeepEqual(twice.AppendTo(nil), []uint{0, 2, 4, 6, 8}) {
+		t.Errorf("unexpected result %v", twice)
+	}
+}
+
 func TestIsSuperSet(t *testing.T) {
 	test := func(name string, lenS, lenSS int, overrideS, overrideSS map[int]bool, want, wantStrict bool) {
 		t.Run(name, func(t *testing.T) {
@@ -1533,6 +2856,12 @@ func TestIsSuperSet(t *testing.T) {
 			if got := ss.IsStrictSuperSet(s); got != wantStrict {
 				t.Errorf("IsStrictSuperSet() = %v, want %v", got, wantStrict)
 			}
+			if got := s.IsSubSet(ss); got != want {
+				t.Errorf("IsSubSet() = %v, want %v", got, want)
+			}
+			if got := s.IsStrictSubSet(ss); got != wantStrict {
+				t.Errorf("IsStrictSubSet() = %v, want %v", got, wantStrict)
+			}
 		})
 	}
 
@@ -1565,6 +2894,143 @@ func TestDumpAsBits(t *testing.T) {
 	}
 }
 
+func TestDumpAsBitsGrouped(t *testing.T) {
+	b := New(300).Set(0).Set(63).Set(64).Set(200).Set(299)
+	for _, perLine := range []int{0, 1, 2, 5, 10} {
+		out := b.DumpAsBitsGrouped(perLine)
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		expectedPerLine := perLine
+		if expectedPerLine < 1 {
+			expectedPerLine = 1
+		}
+		if len(lines) != (5+expectedPerLine-1)/expectedPerLine {
+			t.Errorf("%d words per line: unexpected number of lines %d", perLine, len(lines))
+		}
+		var ones int
+		var word int
+		for _, line := range lines {
+			prefix := fmt.Sprintf("[%d, ", word*64)
+			if !strings.HasPrefix(line, prefix) {
+				t.Errorf("line %q should start with %q", line, prefix)
+			}
+			groups := strings.Fields(line[strings.Index(line, ":")+1:])
+			for _, g := range groups {
+				if len(g) != 64 {
+					t.Errorf("unexpected group %q", g)
+				}
+				// the last character of a group is the bit at a multiple of 64
+				if (g[63] == '1') != b.Test(uint(word*64)) {
+					t.Errorf("group %d does not start at bit %d", word, word*64)
+				}
+				ones += strings.Count(g, "1")
+				word++
+			}
+		}
+		if uint(ones) != b.Count() || word != 5 {
+			t.Errorf("%d words per line: found %d ones in %d words", perLine, ones, word)
+		}
+	}
+	if out := new(BitSet).DumpAsBitsGrouped(4); out != "" {
+		t.Errorf("expected an empty dump, got %q", out)
+	}
+	expected := "[0, 64): 0000000000000000000000000000000000000000000000000000000000000110\n"
+	if out := New(10).Set(1).Set(2).DumpAsBitsGrouped(4); out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestGrowthPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy     GrowthPolicy
+		capacities []int
+	}{
+		{GrowthDouble, []int{4, 10, 10}},
+		{GrowthHalf, []int{3, 7, 12}},
+		{GrowthExact, []int{2, 5, 8}},
+	} {
+		b := New(64).SetGrowthPolicy(tc.policy)
+		for k, i := range []uint{64, 300, 450} {
+			b.Set(i)
+			if b.Capacity() != uint(tc.capacities[k])*64 {
+				t.Errorf("policy %d: expected a capacity of %d words after Set(%d), got %d bits", tc.policy, tc.capacities[k], i, b.Capacity())
+			}
+			if !b.Test(i) || b.Len() != i+1 {
+				t.Errorf("policy %d: Set(%d) failed", tc.policy, i)
+			}
+		}
+	}
+	if b := New(10); b.growth != GrowthDouble {
+		t.Error("the default policy should be GrowthDouble")
+	}
+
+	// the derived BitSets keep the policy
+	b := New(64).Set(3).SetGrowthPolicy(GrowthExact)
+	full := New(10)
+	b.CopyFull(full)
+	for name, c := range map[string]*BitSet{
+		"Clone":               b.Clone(),
+		"CompactCopy":         b.CompactCopy(),
+		"Snapshot":            b.Snapshot(),
+		"Union":               b.Union(New(10)),
+		"Intersection":        b.Intersection(New(100)),
+		"Difference":          b.Difference(New(10)),
+		"SymmetricDifference": b.SymmetricDifference(New(100)),
+		"Complement":          b.Complement(),
+		"CopyFull":            full,
+	} {
+		c.Set(300)
+		if c.Capacity() != 5*64 {
+			t.Errorf("%s: expected a capacity of 5 words with GrowthExact, got %d bits", name, c.Capacity())
+		}
+	}
+}
+
+func TestSizeInBytes(t *testing.T) {
+	overhead := unsafe.Sizeof(BitSet{})
+	var zero BitSet
+	if zero.SizeInBytes() != overhead {
+		t.Errorf("expected %d bytes for the zero value, got %d", overhead, zero.SizeInBytes())
+	}
+	b := New(1000)
+	if b.SizeInBytes() != overhead+16*8 {
+		t.Errorf("expected %d bytes, got %d", overhead+16*8, b.SizeInBytes())
+	}
+	b.Set(5000)
+	if b.SizeInBytes() != overhead+uintptr(cap(b.Words()))*8 || b.SizeInBytes() < overhead+79*8 {
+		t.Errorf("unexpected size %d after growth", b.SizeInBytes())
+	}
+	b.Clear(5000)
+	b.Compact()
+	if b.SizeInBytes() != overhead+uintptr(cap(b.Words()))*8 || b.SizeInBytes() > overhead+16*8 {
+		t.Errorf("unexpected size %d after Compact", b.SizeInBytes())
+	}
+}
+
+func TestWritePBM(t *testing.T) {
+	// a 10x3 grid, whose last row is incomplete
+	//   x.........
+	//   .x......xx
+	//   ..x
+	b := New(23)
+	b.Set(0).Set(11).Set(18).Set(19).Set(22)
+	var buf bytes.Buffer
+	if err := b.WritePBM(&buf, 10); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte("P4\n10 3\n"), 0x80, 0x00, 0x40, 0xc0, 0x20, 0x00)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("expected %q, got %q", expected, buf.Bytes())
+	}
+
+	buf.Reset()
+	if err := new(BitSet).WritePBM(&buf, 8); err != nil || buf.String() != "P4\n8 0\n" {
+		t.Errorf("unexpected output %q for an empty set (%v)", buf.String(), err)
+	}
+	if b.WritePBM(&buf, 0) == nil {
+		t.Error("a zero width should be rejected")
+	}
+}
+
 func TestMarshalUnmarshalBinary(t *testing.T) {
 	a := New(1010).Set(10).Set(1001)
 	b := new(BitSet)
@@ -1607,21 +3073,129 @@ func TestMarshalUnmarshalBinary(t *testing.T) {
 	}
 }
 
-func TestMarshalUnmarshalBinaryByLittleEndian(t *testing.T) {
-	LittleEndian()
-	defer func() {
-		// Revert when done.
-		binaryOrder = binary.BigEndian
-	}()
-	a := New(1010).Set(10).Set(1001)
-	b := new(BitSet)
-
-	copyBinary(t, a, b)
-
-	// BitSets must be equal after marshalling and unmarshalling
-	if !a.Equal(b) {
-		t.Error("Bitsets are not equal:\n\t", a.DumpAsBits(), "\n\t", b.DumpAsBits())
-		return
+func TestMergeBinary(t *testing.T) {
+	for _, lengths := range [][2]uint{{0, 0}, {10, 0}, {0, 10}, {100, 300}, {300, 100}, {64, 64}, {70, 130}} {
+		a, b := New(lengths[0]), New(lengths[1])
+		for i := uint(0); i < lengths[0]; i += 3 {
+			a.Set(i)
+		}
+		for i := uint(0); i < lengths[1]; i += 5 {
+			b.Set(i)
+		}
+		data, err := b.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := a.Union(b)
+		if err := a.MergeBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if !a.Equal(expected) {
+			t.Errorf("lengths %v: expected %v, got %v", lengths, expected, a)
+		}
+	}
+
+	// bits encoded beyond the length are ignored
+	a := New(128)
+	data, _ := FromWithLength(10, []uint64{1<<3 | 1<<20}).MarshalBinary()
+	if err := a.MergeBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equal(New(128).Set(3)) {
+		t.Errorf("unexpected result: %v", a)
+	}
+
+	// truncated data
+	data, _ = New(200).Set(150).MarshalBinary()
+	for _, n := range []int{0, 5, 8, 16, len(data) - 1} {
+		b := New(10).Set(1)
+		if err := b.MergeBinary(data[:n]); err != io.ErrUnexpectedEOF {
+			t.Errorf("expected io.ErrUnexpectedEOF for %d bytes, got %v", n, err)
+		}
+		if !b.Equal(New(10).Set(1)) {
+			t.Errorf("the BitSet should be unchanged on error: %v", b)
+		}
+	}
+}
+
+func TestMarshalDeltaVarint(t *testing.T) {
+	r := rand.New(rand.NewSource(13))
+	for _, length := range []uint{0, 1, 64, 1000, 100000} {
+		b := New(length)
+		for i := uint(0); i < length; i++ {
+			if r.Intn(10) == 0 {
+				b.Set(i)
+			}
+		}
+		c, err := UnmarshalDeltaVarint(b.MarshalDeltaVarint())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.Equal(b) {
+			t.Errorf("length %d: round trip failed", length)
+		}
+	}
+
+	// a clustered set is tiny
+	b := New(1 << 20)
+	b.FlipRange(1000, 1100).FlipRange(500000, 500050).Set(1<<20 - 1)
+	data := b.MarshalDeltaVarint()
+	raw, _ := b.MarshalBinary()
+	if len(data) > 200 || len(data) >= len(raw)/100 {
+		t.Errorf("expected a small encoding, got %d bytes (%d for MarshalBinary)", len(data), len(raw))
+	}
+	if c, err := UnmarshalDeltaVarint(data); err != nil || !c.Equal(b) {
+		t.Errorf("round trip failed: %v", err)
+	}
+
+	// invalid data
+	for _, invalid := range [][]byte{
+		nil,
+		{0x80},        // truncated length
+		{10, 10},      // index beyond the length
+		{10, 3, 5, 1}, // index beyond the length
+		{10, 3, 0x80}, // truncated gap
+		{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40},          // length too large to be allocated
+		{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40, 0x80, 1}, // same, with a set bit
+	} {
+		if _, err := UnmarshalDeltaVarint(invalid); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+	if c, err := UnmarshalDeltaVarint([]byte{10, 3, 5}); err != nil || !c.Equal(New(10).Set(3).Set(9)) {
+		t.Errorf("unexpected result %v, %v", c, err)
+	}
+
+	// the length is bounded, whatever the size of the input
+	huge := []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x10} // 2^46
+	if _, err := UnmarshalDeltaVarint(huge); err == nil || !strings.Contains(err.Error(), "SetMaxDecodedLength") {
+		t.Errorf("expected an error for a huge length, got %v", err)
+	}
+	SetMaxDecodedLength(100)
+	defer SetMaxDecodedLength(defaultMaxDecodedLength)
+	if _, err := UnmarshalDeltaVarint([]byte{101}); err == nil {
+		t.Error("expected an error beyond the limit")
+	}
+	if c, err := UnmarshalDeltaVarint([]byte{100, 99}); err != nil || c.Len() != 100 || !c.Test(99) {
+		t.Errorf("unexpected result at the limit %v, %v", c, err)
+	}
+}
+
+func TestMarshalUnmarshalBinaryByLittleEndian(t *testing.T) {
+	LittleEndian()
+	defer func() {
+		// Revert when done.
+		binaryOrder = binary.BigEndian
+	}()
+	a := New(1010).Set(10).Set(1001)
+	b := new(BitSet)
+
+	copyBinary(t, a, b)
+
+	// BitSets must be equal after marshalling and unmarshalling
+	if !a.Equal(b) {
+		t.Error("Bitsets are not equal:\n\t", a.DumpAsBits(), "\n\t", b.DumpAsBits())
+		return
 	}
 }
 
@@ -1685,6 +3259,181 @@ func TestMarshalUnmarshalJSON(t *testing.T) {
 	})
 }
 
+func TestMarshalUnmarshalJSONArray(t *testing.T) {
+	a := New(100).Set(0).Set(1).Set(5).Set(64)
+	a.SetJSONFormat(JSONArray)
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[0,1,5,64]" {
+		t.Errorf("unexpected JSON %s", data)
+	}
+
+	// the length is the largest index plus one
+	b := New(1000).Set(999)
+	if err := json.Unmarshal(data, b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 65 || !reflect.DeepEqual(b.AppendTo(nil), []uint{0, 1, 5, 64}) {
+		t.Errorf("unexpected result %v (len %d)", b, b.Len())
+	}
+	if err := b.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+
+	// unmarshaling keeps the format of the receiver
+	b.SetJSONFormat(JSONArray)
+	if err := json.Unmarshal([]byte("[2,3]"), b); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := json.Marshal(b); err != nil || string(data) != "[2,3]" {
+		t.Errorf("unexpected JSON %s (%v)", data, err)
+	}
+
+	// the format is detected automatically
+	c := New(0)
+	for _, input := range []string{" [3, 200]", `"` + mustMarshalBase64(t, New(201).Set(3).Set(200)) + `"`} {
+		if err := c.UnmarshalJSON([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.AppendTo(nil), []uint{3, 200}) {
+			t.Errorf("%s: unexpected result %v", input, c)
+		}
+	}
+
+	// empty sets
+	data, err = json.Marshal(New(10).SetJSONFormat(JSONArray))
+	if err != nil || string(data) != "[]" {
+		t.Errorf("unexpected JSON %s for an empty set (%v)", data, err)
+	}
+	if err := c.UnmarshalJSON(data); err != nil || c.Len() != 0 {
+		t.Errorf("unexpected result %v (%v)", c, err)
+	}
+
+	if c.UnmarshalJSON([]byte("[1,-2]")) == nil {
+		t.Error("negative indexes should be rejected")
+	}
+	if c.UnmarshalJSON([]byte("[1,")) == nil {
+		t.Error("malformed input should be rejected")
+	}
+	// indexes beyond the limit of SetMaxDecodedLength
+	for _, input := range []string{fmt.Sprint("[", Cap(), "]"), fmt.Sprint("[1,", Cap()-1, "]"), "[1000000000000000]"} {
+		if err := c.UnmarshalJSON([]byte(input)); err == nil {
+			t.Errorf("%s: huge indexes should be rejected", input)
+		}
+	}
+	SetMaxDecodedLength(100)
+	if c.UnmarshalJSON([]byte("[3,100]")) == nil {
+		t.Error("an index at the limit should be rejected")
+	}
+	if err := c.UnmarshalJSON([]byte("[3,99]")); err != nil || c.Len() != 100 {
+		t.Errorf("an index below the limit should be accepted: %v", err)
+	}
+	SetMaxDecodedLength(defaultMaxDecodedLength)
+
+	// the format is kept by the BitSets derived from a
+	other := New(10).Set(2)
+	var dst BitSet
+	a.CopyFull(&dst)
+	derived := []*BitSet{a.Clone(), a.CompactCopy(), a.Union(other), a.Intersection(other),
+		a.Difference(other), a.SymmetricDifference(other), a.Complement(), a.Snapshot(), &dst}
+	for k, d := range derived {
+		if data, err := json.Marshal(d); err != nil || data[0] != '[' {
+			t.Errorf("derived set %d: unexpected JSON %s (%v)", k, data, err)
+		}
+	}
+	if data, err := json.Marshal(other.Union(a)); err != nil || data[0] != '"' {
+		t.Errorf("the result should have the format of the receiver: %s (%v)", data, err)
+	}
+}
+
+func TestMarshalUnmarshalJSONDeltaVarint(t *testing.T) {
+	sparse := New(1<<20 + 1).Set(3).Set(1000).Set(1 << 20)
+	dense := New(1000)
+	for i := uint(0); i < 1000; i += 2 {
+		dense.Set(i)
+	}
+	base64Size := func(b *BitSet) int {
+		data, err := json.Marshal(b.Clone())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(data)
+	}
+	for _, tc := range []struct {
+		b      *BitSet
+		format JSONFormat
+		delta  bool // whether the delta-varint form is expected
+		small  bool // whether the output should be much smaller than with JSONBase64
+	}{
+		{sparse, JSONDeltaVarint, true, true},
+		{sparse, JSONAuto, true, true},
+		{dense, JSONDeltaVarint, true, false},
+		{dense, JSONAuto, false, false},
+		{New(0), JSONAuto, true, false},
+	} {
+		a := tc.b.Clone().SetJSONFormat(tc.format)
+		data, err := json.Marshal(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.HasPrefix(data, []byte(`"dv:`)); got != tc.delta {
+			t.Errorf("format %d: unexpected JSON %.40s", tc.format, data)
+		}
+		if tc.small && len(data)*100 > base64Size(tc.b) {
+			t.Errorf("format %d: expected a much smaller output than %d bytes, got %d", tc.format, base64Size(tc.b), len(data))
+		}
+		if tc.format == JSONAuto && len(data) > base64Size(tc.b) {
+			t.Errorf("JSONAuto should not be larger than JSONBase64: %d > %d", len(data), base64Size(tc.b))
+		}
+
+		c := New(10).Set(5)
+		if err := json.Unmarshal(data, c); err != nil {
+			t.Fatal(err)
+		}
+		if !c.Equal(tc.b) {
+			t.Errorf("format %d: round trip failed", tc.format)
+		}
+	}
+
+	// malformed or oversized input is rejected, and the BitSet is unchanged
+	c := New(10).Set(5)
+	for _, payload := range [][]byte{
+		{},            // no length
+		{0x80},        // truncated length
+		{10, 10},      // index beyond the length
+		{10, 3, 0x80}, // truncated gap
+		{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40, 0},                // length too large to be allocated
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, // length overflowing 64 bits
+	} {
+		input := `"dv:` + base64Encoding.EncodeToString(payload) + `"`
+		if c.UnmarshalJSON([]byte(input)) == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+		if c.Len() != 10 || c.Count() != 1 || !c.Test(5) {
+			t.Errorf("%s: the BitSet was modified: %v", input, c)
+		}
+	}
+	for _, input := range []string{`"dv:!!"`, `"dv`, `"dv:`} {
+		if c.UnmarshalJSON([]byte(input)) == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func mustMarshalBase64(t *testing.T, b *BitSet) string {
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
 func TestMarshalUnmarshalJSONWithTrailingData(t *testing.T) {
 	a := New(1010).Set(10).Set(1001)
 	data, err := json.Marshal(a)
@@ -1762,6 +3511,32 @@ func TestSetBitsetFrom(t *testing.T) {
 	}
 }
 
+func TestSetBitsetFromWithLength(t *testing.T) {
+	u := []uint64{2, 3, 5, 7, 11}
+	b := new(BitSet)
+	b.SetBitsetFromWithLength(100, u)
+	if b.Len() != 100 {
+		t.Errorf("expected length 100, got %d", b.Len())
+	}
+	if !b.Test(1) || !b.Test(64) || !b.Test(65) {
+		t.Error("expected bits 1, 64 and 65 to be set")
+	}
+	// bits above the length are ignored
+	if b.Test(128) || b.Test(130) {
+		t.Error("bits beyond the length should not be reported")
+	}
+	if !b.Equal(FromWithLength(100, []uint64{2, 3})) {
+		t.Error("bitsets should be equal irrespective of the underlying capacity")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("a slice that is too short should panic")
+		}
+	}()
+	b.SetBitsetFromWithLength(1000, u)
+}
+
 func TestIssue116(t *testing.T) {
 	a := []uint64{2, 3, 5, 7, 11}
 	b := []uint64{2, 3, 5, 7, 11, 0, 1}
@@ -1783,6 +3558,117 @@ func TestFrom(t *testing.T) {
 	}
 }
 
+func TestNewWithWords(t *testing.T) {
+	words := []uint64{1, 0}
+	b := NewWithWords(100, words)
+	if b.Len() != 100 || b.Count() != 1 || !b.Test(0) {
+		t.Errorf("unexpected bitset %v (len %d)", b, b.Len())
+	}
+	// the slice is shared, not copied
+	words[1] = 1
+	if !b.Test(64) {
+		t.Error("a mutation of the input slice should be reflected in the BitSet")
+	}
+	b.Set(2)
+	if words[0] != 5 {
+		t.Error("a mutation of the BitSet should be reflected in the input slice")
+	}
+	// the same holds for From and FromWithLength
+	words = []uint64{0, 0}
+	c, d := From(words), FromWithLength(128, words)
+	words[0] = 8
+	if !c.Test(3) || !d.Test(3) {
+		t.Error("From and FromWithLength should not copy the input slice")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("a slice that is too short should panic")
+		}
+	}()
+	NewWithWords(129, words)
+}
+
+func TestFromBytes(t *testing.T) {
+	data := []byte{0x01, 0x80, 0x00, 0xff, 0x00, 0x00, 0x00, 0x00, 0x03, 0x40}
+	b := FromBytes(data)
+	if b.Len() != 80 {
+		t.Errorf("expected length 80, got %d", b.Len())
+	}
+	expected := []uint{0, 15, 24, 25, 26, 27, 28, 29, 30, 31, 64, 65, 78}
+	if !reflect.DeepEqual(b.AppendTo(nil), expected) {
+		t.Errorf("expected %v, got %v", expected, b.AppendTo(nil))
+	}
+	if got := b.ToBytes(); !bytes.Equal(got, data) {
+		t.Errorf("round-trip failed: %v != %v", got, data)
+	}
+
+	if b := FromBytes(nil); b.Len() != 0 || len(b.ToBytes()) != 0 {
+		t.Error("empty input should give an empty bitset")
+	}
+
+	// non byte-aligned lengths
+	c := New(13)
+	c.Set(0).Set(9).Set(12)
+	if got := c.ToBytes(); !bytes.Equal(got, []byte{0x01, 0x12}) {
+		t.Errorf("unexpected bytes %v", got)
+	}
+
+	// the input is copied
+	data[0] = 0
+	if !b.Test(0) {
+		t.Error("FromBytes should copy its input")
+	}
+}
+
+func TestUint32Slice(t *testing.T) {
+	b := New(100)
+	b.Set(0).Set(31).Set(32).Set(63).Set(64).Set(99)
+	words := b.ToUint32Slice(nil)
+	expected := []uint32{0x80000001, 0x80000001, 0x00000001, 0x00000008}
+	if !reflect.DeepEqual(words, expected) {
+		t.Errorf("expected %x, got %x", expected, words)
+	}
+	c := FromUint32Slice(100, words)
+	if !c.Equal(b) {
+		t.Errorf("round-trip failed: %v != %v", c, b)
+	}
+
+	// appending to an existing slice
+	words = b.ToUint32Slice([]uint32{42})
+	if len(words) != 5 || words[0] != 42 {
+		t.Errorf("unexpected result %x", words)
+	}
+
+	// the high half of the final word
+	for _, length := range []uint{1, 32, 33, 63, 64, 65, 96, 127, 128} {
+		b := New(length)
+		b.Set(0).Set(length - 1)
+		words := b.ToUint32Slice(nil)
+		if uint(len(words)) != (length+31)/32 {
+			t.Errorf("length %d: expected %d words, got %d", length, (length+31)/32, len(words))
+		}
+		if c := FromUint32Slice(length, words); !c.Equal(b) {
+			t.Errorf("length %d: round-trip failed: %v != %v", length, c, b)
+		}
+	}
+
+	// bits beyond the length are ignored
+	if c := FromUint32Slice(40, []uint32{1, 0xffffffff, 0xffffffff}); c.Count() != 9 || c.Len() != 40 {
+		t.Errorf("unexpected result %v", c)
+	}
+	if len(new(BitSet).ToUint32Slice(nil)) != 0 {
+		t.Error("the zero value should give no words")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("a slice that is too short should panic")
+		}
+	}()
+	FromUint32Slice(65, []uint32{1, 2})
+}
+
 func TestWords(t *testing.T) {
 	b := new(BitSet)
 	c := b.Words()
@@ -1798,6 +3684,133 @@ func TestWords(t *testing.T) {
 	}
 }
 
+func TestWordsCopy(t *testing.T) {
+	b := New(130)
+	b.Set(1).Set(64).Set(129)
+	words := b.WordsCopy()
+	if !reflect.DeepEqual(words, b.Words()) {
+		t.Errorf("expected %v, got %v", b.Words(), words)
+	}
+	words[0] = allBits
+	words[2] = 0
+	if b.Count() != 3 || !b.Test(129) || b.Test(0) {
+		t.Errorf("mutating the copy should not affect the bitset: %v", b)
+	}
+
+	// the copy is trimmed to the words needed for Len()
+	c := FromWithLength(100, []uint64{1, 2, 3})
+	if !reflect.DeepEqual(c.WordsCopy(), []uint64{1, 2}) {
+		t.Errorf("expected [1 2], got %v", c.WordsCopy())
+	}
+	if len(new(BitSet).WordsCopy()) != 0 {
+		t.Error("the zero value should have no words")
+	}
+}
+
+func TestWordsRange(t *testing.T) {
+	b := From([]uint64{1, 2, 3, 4, 5})
+	for _, tc := range []struct {
+		start, end int
+		expected   []uint64
+	}{
+		{0, 5, []uint64{1, 2, 3, 4, 5}},
+		{1, 3, []uint64{2, 3}},
+		{-5, 2, []uint64{1, 2}},
+		{3, 100, []uint64{4, 5}},
+		{2, 2, []uint64{}},
+		{4, 1, []uint64{}},
+		{7, 9, []uint64{}},
+	} {
+		got := b.WordsRange(tc.start, tc.end)
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("WordsRange(%d, %d) = %v; expected %v", tc.start, tc.end, got, tc.expected)
+		}
+	}
+
+	words := b.WordsRange(0, 2)
+	words[0] = 42
+	if b.Words()[0] != 1 {
+		t.Error("modifying the result should not affect the bitset")
+	}
+
+	// only the words needed for the length
+	c := New(64)
+	c.set = append(c.set, 7)
+	if got := c.WordsRange(0, 2); len(got) != 1 {
+		t.Errorf("expected 1 word, got %v", got)
+	}
+}
+
+func TestWordCountCapacity(t *testing.T) {
+	var zero BitSet
+	if zero.WordCount() != 0 || zero.Capacity() != 0 {
+		t.Error("the zero value should have no words and no capacity")
+	}
+	b := New(100)
+	if b.WordCount() != len(b.Words()) || b.WordCount() != 2 {
+		t.Errorf("expected 2 words, got %d", b.WordCount())
+	}
+	if b.Capacity() != 128 {
+		t.Errorf("expected a capacity of 128 bits, got %d", b.Capacity())
+	}
+	b.Set(200)
+	if b.WordCount() != 4 {
+		t.Errorf("expected 4 words, got %d", b.WordCount())
+	}
+	if b.Capacity() < b.Len() || b.Capacity()%64 != 0 {
+		t.Errorf("unexpected capacity %d for length %d", b.Capacity(), b.Len())
+	}
+	if b.Capacity() != uint(cap(b.Words()))*64 {
+		t.Errorf("unexpected capacity %d", b.Capacity())
+	}
+}
+
+func TestBitToWordAndOffset(t *testing.T) {
+	b := New(300)
+	for i := uint(0); i < 300; i++ {
+		word, offset := BitToWordAndOffset(i)
+		if word != int(i/64) || offset != i%64 {
+			t.Errorf("bit %d: unexpected location (%d, %d)", i, word, offset)
+		}
+		if j := WordAndOffsetToBit(word, offset); j != i {
+			t.Errorf("bit %d: round-trip gave %d", i, j)
+		}
+		// the mapping agrees with the internal representation
+		b.ClearAll().Set(i)
+		if b.Words()[word] != 1<<offset {
+			t.Errorf("bit %d: not found at word %d, offset %d", i, word, offset)
+		}
+	}
+}
+
+func TestSignificantWords(t *testing.T) {
+	a := New(100)
+	a.Set(3).Set(70)
+	b := FromWithLength(1000, make([]uint64, 20))
+	b.Set(3).Set(70)
+	c := New(5000)
+	c.Set(3).Set(70)
+	c.Compact()
+	for _, s := range []*BitSet{b, c} {
+		if !reflect.DeepEqual(a.SignificantWords(), s.SignificantWords()) {
+			t.Errorf("expected %v, got %v", a.SignificantWords(), s.SignificantWords())
+		}
+	}
+	if !reflect.DeepEqual(a.SignificantWords(), []uint64{8, 64}) {
+		t.Errorf("unexpected words %v", a.SignificantWords())
+	}
+	// the result is a copy
+	a.SignificantWords()[0] = 0
+	if !a.Test(3) {
+		t.Error("mutating the result should not affect the bitset")
+	}
+	for _, empty := range []*BitSet{new(BitSet), New(1000)} {
+		if w := empty.SignificantWords(); w == nil || len(w) != 0 {
+			t.Errorf("expected an empty slice, got %v", w)
+		}
+	}
+}
+
 // Bytes is deprecated
 func TestBytes(t *testing.T) {
 	b := new(BitSet)
@@ -1860,6 +3873,83 @@ func TestClearAll(t *testing.T) {
 		t.Error("All bits should be unset")
 		return
 	}
+
+	// the length is kept, including for a set larger than its length
+	b = New(1000).SetAll()
+	b.Shrink(499)
+	b.ClearAll()
+	if b.Len() != 500 || b.Any() {
+		t.Errorf("unexpected length %d or count %d", b.Len(), b.Count())
+	}
+	for _, w := range b.set {
+		if w != 0 {
+			t.Fatal("all words should be cleared")
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	b := New(1000)
+	b.Set(5).Set(500).Set(999)
+	capacity := cap(b.Words())
+	b.Reset()
+	if !b.Equal(New(0)) {
+		t.Errorf("a reset bitset should be equal to New(0), got %v", b)
+	}
+	if b.Len() != 0 || b.Count() != 0 {
+		t.Errorf("unexpected length (%d) or count (%d) after Reset", b.Len(), b.Count())
+	}
+	if cap(b.Words()) != capacity {
+		t.Errorf("Reset should retain the capacity %d, got %d", capacity, cap(b.Words()))
+	}
+	// growing within the retained capacity must not expose stale bits
+	b.Set(600)
+	if b.Count() != 1 || !b.Test(600) || b.Len() != 601 {
+		t.Errorf("unexpected content after Reset and Set: %v (len %d)", b, b.Len())
+	}
+	if cap(b.Words()) != capacity {
+		t.Errorf("Set should not reallocate after Reset")
+	}
+
+	var zero BitSet
+	zero.Reset()
+	if zero.Len() != 0 {
+		t.Error("Reset of the zero value should be a no-op")
+	}
+	var null *BitSet
+	if null.Reset() != nil {
+		t.Error("Reset of a nil BitSet should return nil")
+	}
+}
+
+func TestPool(t *testing.T) {
+	var pool Pool
+	b := pool.Get()
+	if b == nil || b.Len() != 0 {
+		t.Fatal("Get should return an empty BitSet")
+	}
+	b.Set(100)
+	pool.Put(b)
+	if b.Len() != 0 || b.Any() {
+		t.Error("Put should reset the BitSet")
+	}
+	c := pool.Get()
+	if c.Len() != 0 || c.Any() {
+		t.Errorf("Get should return an empty BitSet, got %v", c)
+	}
+	pool.Put(nil)
+
+	// the options of a previous user are not kept
+	d := pool.Get().SetJSONFormat(JSONArray).SetGrowthPolicy(GrowthExact)
+	d.Set(1)
+	pool.Put(d)
+	d.Set(1)
+	if data, err := json.Marshal(d); err != nil || data[0] != '"' {
+		t.Errorf("Put should reset the JSON format, got %s (%v)", data, err)
+	}
+	if d.growth != GrowthDouble {
+		t.Error("Put should reset the growth policy")
+	}
 }
 
 func TestRankSelect(t *testing.T) {
@@ -1869,74 +3959,631 @@ func TestRankSelect(t *testing.T) {
 		b.Set(v)
 	}
 
-	if b.Rank(5) != 3 {
-		t.Error("Unexpected rank")
-		return
+	if b.Rank(5) != 3 {
+		t.Error("Unexpected rank")
+		return
+	}
+	if b.Rank(6) != 3 {
+		t.Error("Unexpected rank")
+		return
+	}
+	if b.Rank(1500) != 7 {
+		t.Error("Unexpected rank")
+		return
+	}
+	if b.Select(0) != 2 {
+		t.Error("Unexpected select")
+		return
+	}
+	if b.Select(1) != 3 {
+		t.Error("Unexpected select")
+		return
+	}
+	if b.Select(2) != 5 {
+		t.Error("Unexpected select")
+		return
+	}
+
+	if b.Select(5) != 700 {
+		t.Error("Unexpected select")
+		return
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	var empty BitSet
+	if _, ok := empty.Quantile(0.5); ok {
+		t.Error("an empty BitSet has no quantile")
+	}
+
+	b := New(2000)
+	for _, v := range []uint{2, 3, 5, 7, 11, 700, 1500} {
+		b.Set(v)
+	}
+	for _, tc := range []struct {
+		q        float64
+		expected uint
+	}{
+		{-1, 2}, {0, 2}, {0.1, 3}, {0.5, 7}, {0.9, 700}, {0.95, 1500}, {1, 1500}, {2, 1500}, {math.NaN(), 2},
+	} {
+		got, ok := b.Quantile(tc.q)
+		if !ok || got != tc.expected {
+			t.Errorf("Quantile(%v) = %d, %v; expected %d", tc.q, got, ok, tc.expected)
+		}
+	}
+
+	// agreement with Select for every rank
+	count := b.Count()
+	for j := uint(0); j < count; j++ {
+		q := float64(j) / float64(count-1)
+		if got, _ := b.Quantile(q); got != b.Select(j) {
+			t.Errorf("Quantile(%v) = %d; expected %d", q, got, b.Select(j))
+		}
+	}
+
+	single := New(10).Set(4)
+	if got, ok := single.Quantile(0.7); !ok || got != 4 {
+		t.Errorf("Quantile of a singleton = %d, %v; expected 4", got, ok)
+	}
+}
+
+func TestClearFrom(t *testing.T) {
+	for _, i := range []uint{0, 1, 63, 64, 65, 127, 128, 150, 199, 200, 1000} {
+		b := New(200)
+		for k := uint(0); k < 200; k += 3 {
+			b.Set(k)
+		}
+		before := b.Count()
+		removed := b.OnesBetween(i, 200)
+		b.ClearFrom(i)
+		if b.Len() != 200 {
+			t.Errorf("ClearFrom(%d) should not change the length, got %d", i, b.Len())
+		}
+		if b.Count() != before-removed {
+			t.Errorf("ClearFrom(%d): expected count %d, got %d", i, before-removed, b.Count())
+		}
+		for k := uint(0); k < 200; k++ {
+			if b.Test(k) != (k < i && k%3 == 0) {
+				t.Errorf("ClearFrom(%d): unexpected value for bit %d", i, k)
+			}
+		}
+	}
+	var empty BitSet
+	empty.ClearFrom(10)
+}
+
+func TestClearUpTo(t *testing.T) {
+	for _, i := range []uint{0, 1, 63, 64, 65, 127, 128, 150, 199, 200, 1000} {
+		b := New(200)
+		for k := uint(0); k < 200; k += 3 {
+			b.Set(k)
+		}
+		preserved := b.OnesBetween(i, 200)
+		b.ClearUpTo(i)
+		if b.Len() != 200 {
+			t.Errorf("ClearUpTo(%d) should not change the length, got %d", i, b.Len())
+		}
+		if b.Count() != preserved {
+			t.Errorf("ClearUpTo(%d): expected count %d, got %d", i, preserved, b.Count())
+		}
+		for k := uint(0); k < 200; k++ {
+			if b.Test(k) != (k >= i && k%3 == 0) {
+				t.Errorf("ClearUpTo(%d): unexpected value for bit %d", i, k)
+			}
+		}
+	}
+	var empty BitSet
+	empty.ClearUpTo(10)
+}
+
+func TestIntersectRange(t *testing.T) {
+	r := rand.New(rand.NewSource(14))
+	b := New(300)
+	for i := uint(0); i < 300; i++ {
+		if r.Intn(2) == 0 {
+			b.Set(i)
+		}
+	}
+	original := b.Clone()
+	for _, start := range []uint{0, 1, 63, 64, 65, 128, 150, 299, 300, 400} {
+		for _, end := range []uint{0, 1, 64, 100, 128, 192, 299, 300, 1000} {
+			c := b.IntersectRange(start, end)
+			if c.Len() != b.Len() {
+				t.Fatalf("IntersectRange(%d, %d) changed the length", start, end)
+			}
+			clamped := end
+			if clamped > b.Len() {
+				clamped = b.Len()
+			}
+			var count uint
+			if start < clamped {
+				count = b.OnesBetween(start, clamped)
+			}
+			if c.Count() != count {
+				t.Errorf("IntersectRange(%d, %d): expected a count of %d, got %d", start, end, count, c.Count())
+			}
+			for i := uint(0); i < 300; i++ {
+				if expected := b.Test(i) && i >= start && i < end; c.Test(i) != expected {
+					t.Fatalf("IntersectRange(%d, %d): bit %d should be %v", start, end, i, expected)
+				}
+			}
+		}
+	}
+	if !b.Equal(original) {
+		t.Error("IntersectRange modified the original")
+	}
+}
+
+func TestContainsRange(t *testing.T) {
+	b := New(300)
+	b.FlipRange(10, 250)
+	for _, tc := range []struct {
+		start, end uint
+		expected   bool
+	}{
+		{10, 250, true},
+		{10, 11, true},
+		{64, 128, true},
+		{20, 200, true},
+		{9, 250, false},
+		{10, 251, false},
+		{100, 100, true},
+		{200, 100, true},
+		{250, 300, false},
+		{299, 301, false},
+		{400, 400, false},
+	} {
+		if got := b.ContainsRange(tc.start, tc.end); got != tc.expected {
+			t.Errorf("ContainsRange(%d, %d) = %v; expected %v", tc.start, tc.end, got, tc.expected)
+		}
+	}
+
+	// a single hole
+	for _, hole := range []uint{10, 63, 64, 100, 128, 249} {
+		c := b.Clone().Clear(hole)
+		if c.ContainsRange(10, 250) {
+			t.Errorf("the hole at %d should be detected", hole)
+		}
+		if hole > 10 && !c.ContainsRange(10, hole) {
+			t.Errorf("[10, %d) should be set", hole)
+		}
+	}
+
+	// the whole BitSet
+	full := New(128).SetAll()
+	if !full.ContainsRange(0, 128) || full.ContainsRange(0, 129) {
+		t.Error("unexpected result for a full BitSet")
+	}
+}
+
+func TestSetFirstSetLastClearLast(t *testing.T) {
+	for _, initial := range []uint{0, 10, 100, 1000} {
+		for _, n := range []uint{0, 1, 63, 64, 65, 130, 500} {
+			b := New(initial).Set(initial / 2)
+			b.SetFirst(n)
+			if b.Len() != n || b.Count() != n {
+				t.Errorf("SetFirst(%d) from %d: length %d, count %d", n, initial, b.Len(), b.Count())
+			}
+			if err := b.CheckInvariants(); err != nil {
+				t.Errorf("SetFirst(%d) from %d: %v", n, initial, err)
+			}
+		}
+	}
+
+	for _, length := range []uint{0, 1, 64, 100, 200} {
+		for _, n := range []uint{0, 1, 7, 63, 64, 65, 100, 300} {
+			b := New(length)
+			b.SetLast(n)
+			c := New(length).SetAll()
+			c.ClearLast(n)
+			expected := n
+			if expected > length {
+				expected = length
+			}
+			if b.Len() != length || b.Count() != expected || c.Len() != length || c.Count() != length-expected {
+				t.Errorf("length %d, n %d: unexpected results %v and %v", length, n, b, c)
+				continue
+			}
+			for i := uint(0); i < length; i++ {
+				if b.Test(i) != (i >= length-expected) || c.Test(i) == b.Test(i) {
+					t.Errorf("length %d, n %d: bit %d is wrong", length, n, i)
+					break
+				}
+			}
+			if err := b.CheckInvariants(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+func TestClearRangeWithoutRange(t *testing.T) {
+	b := New(300)
+	for i := uint(0); i < 300; i += 2 {
+		b.Set(i)
+	}
+	b.Set(299)
+	bounds := []uint{0, 1, 5, 63, 64, 65, 127, 128, 129, 200, 299, 300, 400}
+	for _, start := range bounds {
+		for _, end := range bounds {
+			original := b.Clone()
+			c := b.WithoutRange(start, end)
+			if !b.Equal(original) {
+				t.Fatalf("WithoutRange(%d, %d) modified the original", start, end)
+			}
+			clamped := end
+			if clamped > b.Len() {
+				clamped = b.Len()
+			}
+			removed := b.OnesBetween(start, clamped)
+			if c.Count() != b.Count()-removed {
+				t.Errorf("WithoutRange(%d, %d): expected count %d, got %d", start, end, b.Count()-removed, c.Count())
+			}
+			for i := uint(0); i < 300; i++ {
+				if c.Test(i) != (b.Test(i) && (i < start || i >= end)) {
+					t.Errorf("WithoutRange(%d, %d): unexpected value for bit %d", start, end, i)
+				}
+			}
+			if c.Len() != b.Len() {
+				t.Errorf("WithoutRange(%d, %d): the length changed to %d", start, end, c.Len())
+			}
+		}
+	}
+	var empty BitSet
+	empty.ClearRange(0, 100)
+}
+
+func TestFlip(t *testing.T) {
+	b := new(BitSet)
+	c := b.Flip(11)
+	if c.length != 12 {
+		t.Error("Unexpected value: ", c.length)
+		return
+	}
+	d := c.Flip(7)
+	if d.length != 12 {
+		t.Error("Unexpected value: ", d.length)
+		return
+	}
+}
+
+func TestSetWhere(t *testing.T) {
+	b := New(10).Set(2)
+	if b.SetWhere(5, 200, func(i uint) bool { return i%3 == 0 }) != b {
+		t.Error("SetWhere should return its receiver")
+	}
+	if b.Len() != 200 {
+		t.Errorf("expected a length of 200, got %d", b.Len())
+	}
+	for i := uint(0); i < 200; i++ {
+		expected := i == 2 || (i >= 5 && i%3 == 0)
+		if b.Test(i) != expected {
+			t.Errorf("bit %d should be %v", i, expected)
+		}
+	}
+
+	// the predicate is called once per bit, in order
+	var calls []uint
+	New(100).SetWhere(60, 70, func(i uint) bool {
+		calls = append(calls, i)
+		return false
+	})
+	if len(calls) != 10 || calls[0] != 60 || calls[9] != 69 {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+
+	// an empty range leaves the BitSet unchanged
+	c := New(10)
+	c.SetWhere(20, 20, func(uint) bool { return true })
+	c.SetWhere(30, 20, func(uint) bool { return true })
+	if c.Len() != 10 || c.Any() {
+		t.Errorf("unexpected result: %v of length %d", c, c.Len())
+	}
+
+	// growth without set bits
+	d := new(BitSet).SetWhere(0, 100, func(uint) bool { return false })
+	if d.Len() != 100 || d.Any() {
+		t.Errorf("unexpected result: %v of length %d", d, d.Len())
+	}
+}
+
+func TestFlipRange(t *testing.T) {
+	b := new(BitSet)
+	b.Set(1).Set(3).Set(5).Set(7).Set(9).Set(11).Set(13).Set(15)
+	c := b.FlipRange(4, 25)
+	if c.length != 25 {
+		t.Error("Unexpected value: ", c.length)
+		return
+	}
+	d := c.FlipRange(8, 24)
+	if d.length != 25 {
+		t.Error("Unexpected value: ", d.length)
+		return
+	}
+	//
+	for i := uint(0); i < 256; i++ {
+		for j := uint(0); j <= i; j++ {
+			bits := New(i)
+			bits.FlipRange(0, j)
+			c := bits.Count()
+			if c != j {
+				t.Error("Unexpected value: ", c, " expected: ", j)
+				return
+			}
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	b := New(200).Set(1).Set(100)
+	words := &b.set[0]
+
+	s := b.Snapshot()
+	if !s.Equal(b) || &s.set[0] != words {
+		t.Fatal("a snapshot should share the words of the original")
+	}
+
+	// the first modification copies the words
+	b.Set(2).Clear(100)
+	if &b.set[0] == words {
+		t.Error("the original should have copied the shared words")
+	}
+	if s.Test(2) || !s.Test(100) || s.Count() != 2 {
+		t.Errorf("the snapshot was modified: %v", s)
+	}
+	if !b.Test(2) || b.Test(100) {
+		t.Errorf("unexpected original: %v", b)
+	}
+
+	// the snapshot is now the only owner of the words: no second copy
+	s.Set(150)
+	if &s.set[0] != words {
+		t.Error("the last BitSet sharing the words should not copy them")
+	}
+	if b.Test(150) {
+		t.Error("modifying the snapshot should not affect the original")
+	}
+
+	// snapshots of snapshots, modified in turn
+	c := New(10).Set(3)
+	s1 := c.Snapshot()
+	s2 := s1.Snapshot()
+	s1.Set(4)
+	c.InPlaceUnion(New(10).Set(5))
+	s2.ShiftLeft(1)
+	if !c.Equal(New(10).Set(3).Set(5)) || !s1.Equal(New(10).Set(3).Set(4)) || s2.Count() != 1 || !s2.Test(4) {
+		t.Errorf("unexpected values: %v %v %v", c, s1, s2)
+	}
+
+	// a failed SetBitsetFromWithLength keeps the shared words
+	d := New(10).Set(1)
+	s3 := d.Snapshot()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for a short slice")
+			}
+		}()
+		d.SetBitsetFromWithLength(100, []uint64{0})
+	}()
+	d.Set(2)
+	if s3.Test(2) || s3.Count() != 1 {
+		t.Errorf("the snapshot was modified: %v", s3)
+	}
+
+	// the shared words are given up, not copied, when they are replaced
+	e := New(1000).Set(1)
+	s4 := e.Snapshot()
+	e.Reset()
+	if e.SharesBackingWith(s4) || e.Len() != 0 || s4.Count() != 1 {
+		t.Errorf("unexpected values after Reset: %v %v", e, s4)
+	}
+	e.Set(5)
+	s5 := e.Snapshot()
+	if err := e.UnmarshalJSON([]byte(`"dv:` + base64Encoding.EncodeToString([]byte{10, 3}) + `"`)); err != nil {
+		t.Fatal(err)
+	}
+	if e.SharesBackingWith(s5) || !e.Equal(New(10).Set(3)) || !s5.Equal(New(6).Set(5)) {
+		t.Errorf("unexpected values after UnmarshalJSON: %v %v", e, s5)
+	}
+	if e.shared != nil || s4.shared.refs != 1 || s5.shared.refs != 1 {
+		t.Error("the references to the shared words should be released")
+	}
+}
+
+func TestSnapshotMutators(t *testing.T) {
+	other := New(400).Set(2).Set(64).Set(350)
+	mutators := map[string]func(b *BitSet){
+		"Set":                        func(b *BitSet) { b.Set(5) },
+		"SetMaskAt":                  func(b *BitSet) { b.SetMaskAt(3, 0xff) },
+		"SetStrict":                  func(b *BitSet) { _ = b.SetStrict(5) },
+		"SetInt":                     func(b *BitSet) { _ = b.SetInt(5) },
+		"SetTo":                      func(b *BitSet) { b.SetTo(1, false) },
+		"SetIf":                      func(b *BitSet) { b.SetIf(5, true) },
+		"SetFirst":                   func(b *BitSet) { b.SetFirst(10) },
+		"SetLast":                    func(b *BitSet) { b.SetLast(10) },
+		"SetWhere":                   func(b *BitSet) { b.SetWhere(0, 200, func(i uint) bool { return i%3 == 0 }) },
+		"SetAll":                     func(b *BitSet) { b.SetAll() },
+		"SetCoords":                  func(b *BitSet) { b.SetCoords([]uint{1, 2}, []uint{10, 30}) },
+		"SetBitsetFrom":              func(b *BitSet) { b.SetBitsetFrom([]uint64{7}) },
+		"SetBitsetFromWithLength":    func(b *BitSet) { b.SetBitsetFromWithLength(10, []uint64{7}) },
+		"Clear":                      func(b *BitSet) { b.Clear(1) },
+		"ClearFrom":                  func(b *BitSet) { b.ClearFrom(50) },
+		"ClearUpTo":                  func(b *BitSet) { b.ClearUpTo(50) },
+		"ClearRange":                 func(b *BitSet) { b.ClearRange(0, 200) },
+		"ClearLast":                  func(b *BitSet) { b.ClearLast(10) },
+		"ClearAll":                   func(b *BitSet) { b.ClearAll() },
+		"Flip":                       func(b *BitSet) { b.Flip(5) },
+		"FlipRange":                  func(b *BitSet) { b.FlipRange(0, 200) },
+		"InsertAt":                   func(b *BitSet) { b.InsertAt(0) },
+		"DeleteAt":                   func(b *BitSet) { b.DeleteAt(0) },
+		"Shrink":                     func(b *BitSet) { b.Shrink(10) },
+		"Compact":                    func(b *BitSet) { b.ClearFrom(100).Compact() },
+		"Canonicalize":               func(b *BitSet) { b.Canonicalize() },
+		"Reset":                      func(b *BitSet) { b.Reset() },
+		"ShiftLeft":                  func(b *BitSet) { b.ShiftLeft(3) },
+		"ShiftRight":                 func(b *BitSet) { b.ShiftRight(3) },
+		"Reverse":                    func(b *BitSet) { b.Reverse() },
+		"ReverseRange":               func(b *BitSet) { b.ReverseRange(0, 100) },
+		"RotateLeft":                 func(b *BitSet) { b.RotateLeft(7) },
+		"InPlaceUnion":               func(b *BitSet) { b.InPlaceUnion(other) },
+		"InPlaceIntersection":        func(b *BitSet) { b.InPlaceIntersection(other) },
+		"InPlaceDifference":          func(b *BitSet) { b.InPlaceDifference(other) },
+		"InPlaceSymmetricDifference": func(b *BitSet) { b.InPlaceSymmetricDifference(other) },
+		"InPlaceUnionParallel":       func(b *BitSet) { b.InPlaceUnionParallel(other, 2) },
+		"InPlaceUnionReadOnly":       func(b *BitSet) { b.InPlaceUnionReadOnly(NewReadOnlyBitSet([]byte{0xff, 0x01})) },
+		"RemoveSet":                  func(b *BitSet) { b.RemoveSet(other) },
+		"AllocClearN":                func(b *BitSet) { b.AllocClearN(2, nil) },
+		"Copy":                       func(b *BitSet) { other.Copy(b) },
+		"CopyFull":                   func(b *BitSet) { other.CopyFull(b) },
+		"ReadFrom": func(b *BitSet) {
+			var buf bytes.Buffer
+			_, _ = other.WriteTo(&buf)
+			_, _ = b.ReadFrom(&buf)
+		},
+		"UnmarshalBinary": func(b *BitSet) {
+			data, _ := other.MarshalBinary()
+			_ = b.UnmarshalBinary(data)
+		},
+		"MergeBinary": func(b *BitSet) {
+			data, _ := other.MarshalBinary()
+			_ = b.MergeBinary(data)
+		},
+		"UnmarshalJSON": func(b *BitSet) { _ = b.UnmarshalJSON([]byte("[1,2,3]")) },
+	}
+	for name, mutate := range mutators {
+		// modifying the original must not affect the snapshot, and
+		// the other way around
+		for _, original := range []bool{true, false} {
+			b := New(300).Set(1).Set(64).Set(100).Set(299)
+			want := b.Clone()
+			s := b.Snapshot()
+			if original {
+				mutate(b)
+				b, s = s, b
+			} else {
+				mutate(s)
+			}
+			if b.Len() != want.Len() || !b.Equal(want) {
+				t.Errorf("%s: modifying a BitSet changed one sharing its words (original: %v): %v, want %v", name, original, b, want)
+			}
+			if s.SharesBackingWith(b) {
+				t.Errorf("%s: the modified BitSet still shares its words (original: %v)", name, original)
+			}
+		}
+	}
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	// meant to be run with -race: the snapshots are modified concurrently,
+	// each from its own goroutine
+	const n = 8
+	for round := 0; round < 100; round++ {
+		b := New(1000).Set(1).Set(500)
+		var wg sync.WaitGroup
+		for g := 0; g < n; g++ {
+			s := b.Snapshot()
+			wg.Add(1)
+			go func(s *BitSet, g uint) {
+				defer wg.Done()
+				s.Set(g + 2).Clear(500)
+				if !s.Test(1) || !s.Test(g+2) || s.Test(500) || s.Count() != 2 {
+					t.Errorf("unexpected snapshot %v", s)
+				}
+			}(s, uint(g))
+		}
+		b.Set(999)
+		wg.Wait()
+		if !b.Test(1) || !b.Test(500) || !b.Test(999) || b.Count() != 3 {
+			t.Errorf("unexpected original %v", b)
+		}
+	}
+}
+
+func TestSharesBackingWith(t *testing.T) {
+	b := New(500).Set(1).Set(300)
+	if !b.SharesBackingWith(b) {
+		t.Error("a BitSet shares its words with itself")
 	}
-	if b.Rank(6) != 3 {
-		t.Error("Unexpected rank")
-		return
+	if b.SharesBackingWith(b.Clone()) {
+		t.Error("a clone should not share the words")
 	}
-	if b.Rank(1500) != 7 {
-		t.Error("Unexpected rank")
-		return
+	view := From(b.Words())
+	if !b.SharesBackingWith(view) || !view.SharesBackingWith(b) {
+		t.Error("From(Words()) should share the words")
 	}
-	if b.Select(0) != 2 {
-		t.Error("Unexpected select")
-		return
+	tail := From(b.Words()[5:])
+	if !b.SharesBackingWith(tail) {
+		t.Error("a view of the last words should share the words")
 	}
-	if b.Select(1) != 3 {
-		t.Error("Unexpected select")
-		return
+	head := From(b.Words()[:2:2])
+	if head.SharesBackingWith(tail) || !head.SharesBackingWith(b) {
+		t.Error("disjoint views should not share the words")
 	}
-	if b.Select(2) != 5 {
-		t.Error("Unexpected select")
-		return
+	s := b.Snapshot()
+	if !s.SharesBackingWith(b) {
+		t.Error("a snapshot should share the words")
 	}
-
-	if b.Select(5) != 700 {
-		t.Error("Unexpected select")
-		return
+	s.Set(2)
+	if s.SharesBackingWith(b) {
+		t.Error("a modified snapshot should not share the words")
+	}
+	if new(BitSet).SharesBackingWith(b) || b.SharesBackingWith(New(0)) {
+		t.Error("a BitSet without words shares nothing")
 	}
 }
 
-func TestFlip(t *testing.T) {
-	b := new(BitSet)
-	c := b.Flip(11)
-	if c.length != 12 {
-		t.Error("Unexpected value: ", c.length)
-		return
-	}
-	d := c.Flip(7)
-	if d.length != 12 {
-		t.Error("Unexpected value: ", d.length)
-		return
+func TestCompactCopy(t *testing.T) {
+	for _, tc := range []struct {
+		length uint
+		bits   []uint
+		words  int
+		len    uint
+	}{
+		{1000, []uint{1, 100}, 2, 128},
+		{1000, []uint{999}, 16, 1000},
+		{130, []uint{129}, 3, 130},
+		{1000, nil, 0, 0},
+		{0, nil, 0, 0},
+	} {
+		b := New(tc.length)
+		for _, i := range tc.bits {
+			b.Set(i)
+		}
+		c := b.CompactCopy()
+		if c.Len() != tc.len || len(c.set) != tc.words || c.Capacity() != uint(tc.words)*64 {
+			t.Errorf("length %d, bits %v: unexpected length %d, %d words and capacity %d", tc.length, tc.bits, c.Len(), len(c.set), c.Capacity())
+		}
+		if !reflect.DeepEqual(c.AppendTo(nil), b.AppendTo(nil)) {
+			t.Errorf("unexpected bits %v, expected %v", c, b)
+		}
+		if !c.IsSuperSet(b) || !b.IsSuperSet(c) {
+			t.Errorf("length %d, bits %v: the copy should have the same bits", tc.length, tc.bits)
+		}
+		if err := c.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+		c.Set(5)
+		if b.Test(5) {
+			t.Error("the copy should not share the words of the original")
+		}
 	}
 }
 
-func TestFlipRange(t *testing.T) {
-	b := new(BitSet)
-	b.Set(1).Set(3).Set(5).Set(7).Set(9).Set(11).Set(13).Set(15)
-	c := b.FlipRange(4, 25)
-	if c.length != 25 {
-		t.Error("Unexpected value: ", c.length)
-		return
-	}
-	d := c.FlipRange(8, 24)
-	if d.length != 25 {
-		t.Error("Unexpected value: ", d.length)
-		return
-	}
-	//
-	for i := uint(0); i < 256; i++ {
-		for j := uint(0); j <= i; j++ {
-			bits := New(i)
-			bits.FlipRange(0, j)
-			c := bits.Count()
-			if c != j {
-				t.Error("Unexpected value: ", c, " expected: ", j)
-				return
-			}
+func TestIsSingleton(t *testing.T) {
+	for _, tc := range []struct {
+		b        *BitSet
+		expected bool
+	}{
+		{New(0), false},
+		{New(100), false},
+		{New(100).Set(70), true},
+		{New(100).Set(0), true},
+		{New(100).Set(0).Set(1), false},
+		{New(200).Set(3).Set(150), false},
+	} {
+		if tc.b.IsSingleton() != tc.expected {
+			t.Errorf("IsSingleton(%v) should be %v", tc.b, tc.expected)
 		}
 	}
 }
@@ -1953,6 +4600,13 @@ func TestCopy(t *testing.T) {
 		t.Error("Unexpected value")
 		return
 	}
+
+	// the destination keeps its options
+	c := New(20).SetJSONFormat(JSONArray).SetGrowthPolicy(GrowthExact)
+	New(10).Set(1).SetGrowthPolicy(GrowthHalf).Copy(c)
+	if data, err := json.Marshal(c); err != nil || string(data) != "[1]" || c.growth != GrowthExact {
+		t.Errorf("Copy should keep the options of the destination, got %s (%v)", data, err)
+	}
 }
 
 func TestCopyUnaligned(t *testing.T) {
@@ -1983,6 +4637,46 @@ func TestCopyUnaligned(t *testing.T) {
 	}
 }
 
+func TestCopyUnalignedMatrix(t *testing.T) {
+	lengths := []uint{0, 1, 7, 63, 64, 65, 100, 127, 128, 129, 200}
+	for _, srcLen := range lengths {
+		for _, dstLen := range lengths {
+			a := New(srcLen)
+			a.FlipRange(0, srcLen)
+			b := New(dstLen)
+			count := a.Copy(b)
+			expected := srcLen
+			if dstLen < expected {
+				expected = dstLen
+			}
+			if count != expected {
+				t.Errorf("Copy(%d -> %d): expected %d bits copied, got %d", srcLen, dstLen, expected, count)
+			}
+			if b.Len() != dstLen {
+				t.Errorf("Copy(%d -> %d): the destination length changed to %d", srcLen, dstLen, b.Len())
+			}
+			if b.Count() != expected {
+				t.Errorf("Copy(%d -> %d): expected count %d, got %d", srcLen, dstLen, expected, b.Count())
+			}
+			if err := b.CheckInvariants(); err != nil {
+				t.Errorf("Copy(%d -> %d): %v", srcLen, dstLen, err)
+			}
+		}
+	}
+
+	// destination with a backing slice longer than its length
+	a := New(256)
+	a.FlipRange(0, 256)
+	b := FromWithLength(70, make([]uint64, 4))
+	a.Copy(b)
+	if b.Count() != 70 {
+		t.Errorf("expected count 70, got %d", b.Count())
+	}
+	if err := b.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestCopyFull(t *testing.T) {
 	a := New(10)
 	b := &BitSet{}
@@ -2151,6 +4845,138 @@ func TestDeleteWithBitSetInstance(t *testing.T) {
 	}
 }
 
+func TestWriteIndicesText(t *testing.T) {
+	b := New(100000)
+	for i := uint(0); i < 100000; i += 13 {
+		b.Set(i)
+	}
+	b.Set(99999)
+	var buf bytes.Buffer
+	n, err := b.WriteIndicesText(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected strings.Builder
+	for _, i := range b.AsSlice(make([]uint, b.Count())) {
+		expected.WriteString(strconv.Itoa(int(i)))
+		expected.WriteByte('\n')
+	}
+	if buf.String() != expected.String() || n != int64(buf.Len()) {
+		t.Errorf("unexpected output (%d bytes reported, %d written)", n, buf.Len())
+	}
+
+	// the output is streamed
+	allocs := testing.AllocsPerRun(5, func() {
+		if _, err := b.WriteIndicesText(io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 5 {
+		t.Errorf("expected a few allocations, got %v", allocs)
+	}
+
+	if n, err := New(10).WriteIndicesText(&buf); n != 0 || err != nil {
+		t.Errorf("an empty set should write nothing, got %d, %v", n, err)
+	}
+
+	// write errors are reported, along with the number of bytes that
+	// reached the writer
+	if n, err := b.WriteIndicesText(failingWriter{}); err == nil || n != 0 {
+		t.Errorf("expected an error and no byte written, got %d, %v", n, err)
+	}
+	limited := &limitedWriter{limit: 5000}
+	if n, err := b.WriteIndicesText(limited); err == nil || n != 5000 || limited.buf.String() != expected.String()[:5000] {
+		t.Errorf("expected an error after 5000 bytes, got %d, %v", n, err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// limitedWriter accepts up to limit bytes, then fails.
+type limitedWriter struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if room := w.limit - w.buf.Len(); len(p) > room {
+		w.buf.Write(p[:room])
+		return room, errors.New("write failed")
+	}
+	return w.buf.Write(p)
+}
+
+func TestReadIndicesText(t *testing.T) {
+	b := New(5000)
+	for i := uint(3); i < 5000; i += 17 {
+		b.Set(i)
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteIndicesText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ReadIndicesText(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.AppendTo(nil), b.AppendTo(nil)) {
+		t.Error("round trip failed")
+	}
+	if top, _ := b.HighestSet(); c.Len() != top+1 {
+		t.Errorf("expected a length of %d, got %d", top+1, c.Len())
+	}
+
+	// white space and blank lines
+	c, err = ReadIndicesText(strings.NewReader("\n 5 10\t7\n\n\r\n200 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equal(New(201).Set(5).Set(7).Set(10).Set(200)) {
+		t.Errorf("unexpected result %v", c)
+	}
+	if c, err := ReadIndicesText(strings.NewReader("")); err != nil || c.Len() != 0 {
+		t.Errorf("unexpected result %v, %v", c, err)
+	}
+
+	for input, line := range map[string]string{
+		"1\n2\nx\n":               "line 3",
+		"1 -2":                    "line 1",
+		"\n\n3.5":                 "line 3",
+		"99999999999999999999999": "line 1",
+		"1\n\n" + strconv.FormatUint(uint64(Cap()), 10): "line 3",
+		"1\n" + strconv.FormatUint(uint64(Cap()-1), 10): "line 2",
+		"1\n2 1000000000000000":                         "line 2",
+	} {
+		_, err := ReadIndicesText(strings.NewReader(input))
+		if err == nil || !strings.HasPrefix(err.Error(), line+":") {
+			t.Errorf("%q: expected an error at %s, got %v", input, line, err)
+		}
+	}
+
+	// lines longer than the default buffer of bufio.Scanner
+	var long strings.Builder
+	expected := New(0)
+	for i := uint(0); long.Len() < 200000; i += 3 {
+		long.WriteString(strconv.Itoa(int(i)))
+		long.WriteByte(' ')
+		expected.Set(i)
+	}
+	long.WriteString("\n7\nx")
+	expected.Set(7)
+	input := long.String()
+	c, err = ReadIndicesText(strings.NewReader(input[:len(input)-2]))
+	if err != nil || !c.Equal(expected) {
+		t.Errorf("unexpected result for a long line: %v", err)
+	}
+	if _, err := ReadIndicesText(strings.NewReader(input)); err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("expected an error at line 3, got %v", err)
+	}
+}
+
 func TestWriteTo(t *testing.T) {
 	const length = 9585
 	const oneEvery = 97
@@ -2362,6 +5188,113 @@ func TestShiftLeft(t *testing.T) {
 	test("with extension", 242)
 }
 
+func TestShiftLeftToNewWord(t *testing.T) {
+	// the new top bit is the first bit of a word beyond the backing slice
+	for _, tc := range []struct{ length, bit, shift uint }{
+		{1, 0, 64},
+		{64, 63, 1},
+		{100, 70, 58},
+		{128, 127, 129},
+	} {
+		b := New(tc.length).Set(tc.bit)
+		b.ShiftLeft(tc.shift)
+		if !b.Test(tc.bit+tc.shift) || b.Count() != 1 || b.Len() != tc.bit+tc.shift+1 {
+			t.Errorf("%+v: unexpected result %v of length %d", tc, b, b.Len())
+		}
+	}
+}
+
+func TestShiftLeftBounded(t *testing.T) {
+	data := []uint{5, 28, 45, 72, 89}
+
+	test := func(name string, bits, width uint) {
+		t.Run(name, func(t *testing.T) {
+			b := New(100)
+			for _, i := range data {
+				b.Set(i)
+			}
+
+			b.ShiftLeftBounded(bits, width)
+
+			if b.Len() != width {
+				t.Errorf("expected length %d, got %d", width, b.Len())
+			}
+			expected := 0
+			for _, i := range data {
+				if i+bits < width {
+					expected++
+					if !b.Test(i + bits) {
+						t.Errorf("bit %v is not set", i+bits)
+					}
+				}
+			}
+			if int(b.Count()) != expected {
+				t.Errorf("expected %d bits, got %d", expected, b.Count())
+			}
+			if err := b.CheckInvariants(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	test("zero", 0, 100)
+	test("no loss", 10, 100)
+	test("lose one", 11, 100)
+	test("full page shift", 64, 100)
+	test("with page split", 70, 100)
+	test("narrower", 3, 50)
+	test("wider", 100, 300)
+	test("everything lost", 100, 100)
+	test("empty width", 5, 0)
+}
+
+func TestShiftLeftWithCarry(t *testing.T) {
+	for _, length := range []uint{0, 1, 64, 100, 130} {
+		for _, shift := range []uint{0, 1, 7, 63, 64, 65, 99, 100, 129, 130, 200} {
+			b := New(length)
+			for i := uint(0); i < length; i += 3 {
+				b.Set(i)
+			}
+			original := b.Clone()
+			carry := b.ShiftLeftWithCarry(shift)
+
+			if carry.Len() != shift || b.Len() != length {
+				t.Errorf("len %d, shift %d: unexpected lengths %d and %d", length, shift, carry.Len(), b.Len())
+			}
+			// the carry and the register together hold the original content shifted
+			for i := uint(0); i < length; i++ {
+				pos := i + shift
+				var got bool
+				if pos < length {
+					got = b.Test(pos)
+				} else {
+					got = carry.Test(pos - length)
+				}
+				if got != original.Test(i) {
+					t.Errorf("len %d, shift %d: bit %d was lost", length, shift, i)
+				}
+			}
+			if b.Count()+carry.Count() != original.Count() {
+				t.Errorf("len %d, shift %d: expected %d bits in total, got %d+%d", length, shift, original.Count(), b.Count(), carry.Count())
+			}
+			if err := carry.CheckInvariants(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// the carry holds the expected high bits
+	b := New(128)
+	b.Set(0).Set(120).Set(127)
+	carry := b.ShiftLeftWithCarry(10)
+	if !reflect.DeepEqual(carry.AppendTo(nil), []uint{2, 9}) {
+		t.Errorf("unexpected carry %v", carry)
+	}
+	if !reflect.DeepEqual(b.AppendTo(nil), []uint{10}) {
+		t.Errorf("unexpected result %v", b)
+	}
+}
+
 func TestShiftRight(t *testing.T) {
 	data := []uint{5, 28, 45, 72, 89}
 
@@ -2376,7 +5309,7 @@ func TestShiftRight(t *testing.T) {
 
 			count := 0
 			for _, i := range data {
-				if i > bits {
+				if i >= bits {
 					count++
 
 					if !b.Test(i - bits) {
@@ -2398,9 +5331,132 @@ func TestShiftRight(t *testing.T) {
 	test("full page shift", 64)
 	test("with extension", 70)
 	test("full shift", 89)
+	test("shift past the top", 90)
 	test("remove all", 242)
 }
 
+func TestShift(t *testing.T) {
+	data := []uint{0, 5, 28, 45, 63, 72, 89}
+	build := func() *BitSet {
+		b := New(130)
+		for _, i := range data {
+			b.Set(i)
+		}
+		return b
+	}
+	for _, delta := range []int{0, 1, 19, 63, 64, 65, 128, 200, -1, -19, -63, -64, -65, -128, -200} {
+		expected := build()
+		if delta >= 0 {
+			expected.ShiftLeft(uint(delta))
+		} else {
+			expected.ShiftRight(uint(-delta))
+		}
+		b := build()
+		if b.Shift(delta) != b {
+			t.Error("Shift should return its receiver")
+		}
+		if !b.Equal(expected) {
+			t.Errorf("Shift(%d) = %v; expected %v", delta, b, expected)
+		}
+	}
+	if b := build(); !b.Shift(0).Equal(build()) {
+		t.Error("Shift(0) should not modify the BitSet")
+	}
+	if b := New(10).Set(3); b.Shift(math.MinInt64 >> (64 - bits.UintSize)).Any() {
+		t.Error("shifting right by a huge amount should clear all bits")
+	}
+
+	// the top bit moves to the start of a new word
+	b := New(1).Set(0)
+	b.Shift(64)
+	if !b.Test(64) || b.Count() != 1 || b.Len() != 65 {
+		t.Errorf("unexpected result: %v of length %d", b, b.Len())
+	}
+}
+
+func TestReverseRange(t *testing.T) {
+	r := rand.New(rand.NewSource(12))
+	for _, length := range []uint{0, 1, 2, 63, 64, 65, 130, 500} {
+		b := New(length)
+		for i := uint(0); i < length; i++ {
+			if r.Intn(2) == 0 {
+				b.Set(i)
+			}
+		}
+		for trial := 0; trial < 20; trial++ {
+			start := uint(r.Intn(int(length) + 1))
+			end := start + uint(r.Intn(int(length-start)+1))
+			c := b.Clone().ReverseRange(start, end)
+			for i := uint(0); i < length; i++ {
+				j := i
+				if i >= start && i < end {
+					j = start + end - 1 - i
+				}
+				if c.Test(i) != b.Test(j) {
+					t.Fatalf("length %d, range [%d, %d): bit %d is wrong", length, start, end, i)
+				}
+			}
+			if err := c.CheckInvariants(); err != nil {
+				t.Fatal(err)
+			}
+			if !c.ReverseRange(start, end).Equal(b) {
+				t.Fatalf("length %d, range [%d, %d): reversing twice should restore the set", length, start, end)
+			}
+		}
+		c := b.Clone().Reverse()
+		if !c.Equal(b.Clone().ReverseRange(0, length)) {
+			t.Errorf("length %d: Reverse should be ReverseRange(0, Len())", length)
+		}
+		for i := uint(0); i < length; i++ {
+			if c.Test(i) != b.Test(length-1-i) {
+				t.Fatalf("length %d: bit %d is wrong after Reverse", length, i)
+			}
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("ReverseRange beyond the length should panic")
+		}
+	}()
+	New(10).ReverseRange(5, 11)
+}
+
+func TestRotateLeft(t *testing.T) {
+	r := rand.New(rand.NewSource(13))
+	for _, length := range []uint{0, 1, 2, 63, 64, 65, 100, 129, 500} {
+		b := New(length)
+		for i := uint(0); i < length; i++ {
+			if r.Intn(3) == 0 {
+				b.Set(i)
+			}
+		}
+		for _, k := range []uint{0, 1, 7, 63, 64, 65, length - 1, length, length + 3, uint(r.Intn(1000))} {
+			c := b.Clone().RotateLeft(k)
+			// reference: the shifted bits, and the wrapped bits shifted back
+			expected := New(length)
+			if length > 0 {
+				s := k % length
+				expected = b.Clone().ShiftLeftBounded(s, length)
+				wrapped := b.Clone()
+				wrapped.ShiftRight(length - s)
+				expected.InPlaceUnion(wrapped)
+			}
+			if !c.Equal(expected) {
+				t.Fatalf("length %d, k %d: expected %v, got %v", length, k, expected, c)
+			}
+			if c.Len() != length || c.Count() != b.Count() {
+				t.Fatalf("length %d, k %d: unexpected length %d or count %d", length, k, c.Len(), c.Count())
+			}
+		}
+	}
+	// bit i moves to (i+k) mod Len()
+	b := New(10).Set(0).Set(8).RotateLeft(3)
+	if !b.Equal(New(10).Set(3).Set(1)) {
+		t.Errorf("unexpected rotation %v", b)
+	}
+}
+
 func TestWord(t *testing.T) {
 	data := []uint64{0x0bfd85fc01af96dd, 0x3fe212a7eae11414, 0x7aa412221245dee1, 0x557092c1711306d5}
 	testCases := map[string]struct {
@@ -2501,6 +5557,76 @@ func TestPreviousSet(t *testing.T) {
 	}
 }
 
+func TestHighestSet(t *testing.T) {
+	b := New(1000).Set(5)
+	if i, ok := b.HighestSet(); !ok || i != 5 || b.Len() != 1000 {
+		t.Errorf("HighestSet() = %d, %v with Len() = %d; expected 5, true with 1000", i, ok, b.Len())
+	}
+	b.Set(64).Set(999)
+	if i, ok := b.HighestSet(); !ok || i != 999 {
+		t.Errorf("HighestSet() = %d, %v; expected 999", i, ok)
+	}
+	b.Clear(999)
+	if i, ok := b.HighestSet(); !ok || i != 64 {
+		t.Errorf("HighestSet() = %d, %v; expected 64", i, ok)
+	}
+	for _, empty := range []*BitSet{New(0), New(1000), {}} {
+		if _, ok := empty.HighestSet(); ok {
+			t.Error("an empty BitSet has no highest set bit")
+		}
+	}
+}
+
+func TestNearestSet(t *testing.T) {
+	b := New(1000).Set(10).Set(20).Set(100).Set(500)
+	for _, tc := range []struct{ i, expected uint }{
+		{0, 10},     // only above
+		{10, 10},    // set
+		{12, 10},    // below is closer
+		{18, 20},    // above is closer
+		{15, 10},    // equidistant: the lower index
+		{60, 20},    // equidistant
+		{61, 100},   // above is closer
+		{499, 500},  // across words
+		{999, 500},  // only below
+		{5000, 500}, // beyond the length
+	} {
+		if got, ok := b.NearestSet(tc.i); !ok || got != tc.expected {
+			t.Errorf("NearestSet(%d) = %d, %v; expected %d", tc.i, got, ok, tc.expected)
+		}
+	}
+
+	r := rand.New(rand.NewSource(21))
+	c := New(700)
+	for k := 0; k < 20; k++ {
+		c.Set(uint(r.Intn(700)))
+	}
+	for i := uint(0); i < 800; i++ {
+		best, found := uint(0), false
+		for j, ok := c.NextSet(0); ok; j, ok = c.NextSet(j + 1) {
+			d, bd := int(j)-int(i), int(best)-int(i)
+			if d < 0 {
+				d = -d
+			}
+			if bd < 0 {
+				bd = -bd
+			}
+			if !found || d < bd {
+				best, found = j, true
+			}
+		}
+		if got, ok := c.NearestSet(i); !ok || got != best {
+			t.Fatalf("NearestSet(%d) = %d, %v; expected %d", i, got, ok, best)
+		}
+	}
+
+	for _, empty := range []*BitSet{New(0), New(100), {}} {
+		if _, ok := empty.NearestSet(50); ok {
+			t.Error("an empty BitSet has no set bit")
+		}
+	}
+}
+
 func TestPreviousClear(t *testing.T) {
 	v := New(128)
 	v.Set(0)
@@ -2611,6 +5737,35 @@ func TestBitSetOnesBetween(t *testing.T) {
 	}
 }
 
+func TestCountBitsInWords(t *testing.T) {
+	b := New(1000)
+	for i := uint(0); i < 1000; i += 3 {
+		b.Set(i)
+	}
+	words := b.Words()
+	for start := -2; start <= len(words)+2; start++ {
+		for end := start - 1; end <= len(words)+2; end++ {
+			var expected uint
+			for i := start; i < end; i++ {
+				if i >= 0 && i < len(words) {
+					expected += uint(bits.OnesCount64(words[i]))
+				}
+			}
+			if got := b.CountBitsInWords(start, end); got != expected {
+				t.Errorf("CountBitsInWords(%d, %d): expected %d, got %d", start, end, expected, got)
+			}
+			if start >= 0 && end >= start && end < len(words) {
+				if got := b.OnesBetween(uint(start)*64, uint(end)*64); got != b.CountBitsInWords(start, end) {
+					t.Errorf("CountBitsInWords(%d, %d) should match OnesBetween, got %d", start, end, got)
+				}
+			}
+		}
+	}
+	if new(BitSet).CountBitsInWords(0, 10) != 0 {
+		t.Error("the zero value should have no set bits")
+	}
+}
+
 func BenchmarkBitSetOnesBetween(b *testing.B) {
 	sizes := []int{64, 256, 1024, 4096, 16384}
 	densities := []float64{0.1, 0.5, 0.9} // Different bit densities to test
@@ -0,0 +1,12 @@
+//go:build !go1.21
+// +build !go1.21
+
+package bitset
+
+// clearWords sets all the words of s to 0. The compiler recognizes
+// the loop and replaces it by a call to memclr.
+func clearWords(s []uint64) {
+	for i := range s {
+		s[i] = 0
+	}
+}
@@ -0,0 +1,9 @@
+//go:build go1.21
+// +build go1.21
+
+package bitset
+
+// clearWords sets all the words of s to 0.
+func clearWords(s []uint64) {
+	clear(s)
+}
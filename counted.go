@@ -0,0 +1,120 @@
+package bitset
+
+// CountedBitSet is a BitSet which caches its number of set bits, so that
+// Count, Any, None, All, IsSingleton and Parity take constant time. Set,
+// Clear, Flip and SetTo maintain the count, at the cost of an additional
+// test of the bit; the other modifications go through Update, which counts
+// the bits again. This is valuable in query-heavy workloads.
+type CountedBitSet struct {
+	bits  *BitSet
+	count uint
+}
+
+// NewCounted creates a CountedBitSet of the given length, with no bit set.
+func NewCounted(length uint) *CountedBitSet {
+	return &CountedBitSet{bits: New(length)}
+}
+
+// Len returns the number of bits in the CountedBitSet.
+func (c *CountedBitSet) Len() uint {
+	return c.bits.Len()
+}
+
+// Test whether bit i is set.
+func (c *CountedBitSet) Test(i uint) bool {
+	return c.bits.Test(i)
+}
+
+// Set bit i to 1, extending the CountedBitSet if needed, see BitSet.Set.
+func (c *CountedBitSet) Set(i uint) *CountedBitSet {
+	if !c.bits.Test(i) {
+		c.bits.Set(i)
+		c.count++
+	}
+	return c
+}
+
+// Clear bit i to 0.
+func (c *CountedBitSet) Clear(i uint) *CountedBitSet {
+	if c.bits.Test(i) {
+		c.bits.Clear(i)
+		c.count--
+	}
+	return c
+}
+
+// Flip bit i, extending the CountedBitSet if needed, see BitSet.Flip.
+func (c *CountedBitSet) Flip(i uint) *CountedBitSet {
+	if c.bits.Test(i) {
+		c.count--
+	} else {
+		c.count++
+	}
+	c.bits.Flip(i)
+	return c
+}
+
+// SetTo sets bit i to value.
+func (c *CountedBitSet) SetTo(i uint, value bool) *CountedBitSet {
+	if value {
+		return c.Set(i)
+	}
+	return c.Clear(i)
+}
+
+// ClearAll clears all the bits, leaving the length unchanged.
+func (c *CountedBitSet) ClearAll() *CountedBitSet {
+	c.bits.ClearAll()
+	c.count = 0
+	return c
+}
+
+// Update calls fn with the underlying BitSet, which fn may modify in any
+// way, and counts the bits again. The BitSet must not be retained by fn.
+func (c *CountedBitSet) Update(fn func(b *BitSet)) *CountedBitSet {
+	fn(c.bits)
+	c.count = c.bits.Count()
+	return c
+}
+
+// Count returns the number of set bits.
+func (c *CountedBitSet) Count() uint {
+	return c.count
+}
+
+// Any returns true if any bit is set.
+func (c *CountedBitSet) Any() bool {
+	return c.count > 0
+}
+
+// None returns true if no bit is set.
+func (c *CountedBitSet) None() bool {
+	return c.count == 0
+}
+
+// All returns true if all bits are set; it returns true for an empty set.
+func (c *CountedBitSet) All() bool {
+	return c.count == c.bits.Len()
+}
+
+// IsSingleton returns true if exactly one bit is set.
+func (c *CountedBitSet) IsSingleton() bool {
+	return c.count == 1
+}
+
+// Parity returns true if and only if the number of set bits is odd.
+func (c *CountedBitSet) Parity() bool {
+	return c.count&1 == 1
+}
+
+// BitSet returns the bits as a BitSet, which is a snapshot (see
+// BitSet.Snapshot): it is cheap to get, and modifying it does not affect
+// the CountedBitSet.
+func (c *CountedBitSet) BitSet() *BitSet {
+	return c.bits.Snapshot()
+}
+
+// String returns the bits as a string, see BitSet.String.
+func (c *CountedBitSet) String() string {
+	return c.bits.String()
+}
@@ -0,0 +1,79 @@
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestNewCounted(t *testing.T) {
+	r := rand.New(rand.NewSource(16))
+	c := NewCounted(300)
+	check := func(op string) {
+		t.Helper()
+		expected := uint(popcntSlice(c.bits.set))
+		if got := c.Count(); got != expected {
+			t.Fatalf("after %s: expected a count of %d, got %d", op, expected, got)
+		}
+		if c.Any() != (expected > 0) || c.None() != (expected == 0) || c.IsSingleton() != (expected == 1) ||
+			c.All() != (expected == c.Len()) || c.Parity() != (expected%2 == 1) {
+			t.Fatalf("after %s: Any, None, All, IsSingleton or Parity is inconsistent with a count of %d", op, expected)
+		}
+	}
+	check("NewCounted")
+	for k := 0; k < 3000; k++ {
+		i := uint(r.Intn(400))
+		switch r.Intn(12) {
+		case 0, 1, 2:
+			c.Set(i)
+			check("Set")
+		case 3, 4:
+			c.Clear(i)
+			check("Clear")
+		case 5, 6:
+			c.Flip(i)
+			check("Flip")
+		case 7:
+			c.SetTo(i, r.Intn(2) == 0)
+			check("SetTo")
+		case 8:
+			c.Update(func(b *BitSet) { b.FlipRange(i, i+uint(r.Intn(100))) })
+			check("FlipRange")
+		case 9:
+			c.Update(func(b *BitSet) { b.InPlaceUnion(New(500).Set(i)) })
+			check("InPlaceUnion")
+		case 10:
+			c.Update(func(b *BitSet) { b.SetBitsetFrom([]uint64{r.Uint64(), r.Uint64()}) })
+			check("SetBitsetFrom")
+		default:
+			if r.Intn(10) == 0 {
+				c.ClearAll()
+				check("ClearAll")
+			} else {
+				words := make([]uint64, wordsNeeded(i))
+				for k := range words {
+					words[k] = r.Uint64()
+				}
+				c.Update(func(b *BitSet) {
+					b.SetBitsetFromWithLength(i, words)
+					b.cleanLastWord()
+				})
+				check("SetBitsetFromWithLength")
+			}
+		}
+	}
+
+	d := NewCounted(100).Set(1).Set(1).Set(70).Flip(3).Flip(1).Clear(50).Set(200)
+	if d.Count() != 3 || d.Len() != 201 || !d.Test(200) || d.String() != "{3,70,200}" {
+		t.Errorf("unexpected counted set %v with count %d", d, d.Count())
+	}
+	if !NewCounted(0).All() || NewCounted(2).Set(0).All() || !NewCounted(2).Set(0).Set(1).All() {
+		t.Error("unexpected result of All")
+	}
+
+	// the BitSet is a snapshot
+	b := d.BitSet()
+	b.Set(5)
+	if d.Test(5) || d.Count() != 3 || !b.Test(70) {
+		t.Errorf("modifying the BitSet should not affect the counted set: %v", d)
+	}
+}
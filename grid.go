@@ -0,0 +1,91 @@
+package bitset
+
+import "io"
+
+// Grid2D is a two-dimensional grid of bits of fixed width and height, stored
+// in a BitSet in row-major order: the cell at column x and row y is the bit
+// y*width+x. It is convenient for cellular automata, mazes and images.
+type Grid2D struct {
+	bits          *BitSet
+	width, height uint
+}
+
+// NewGrid2D creates a grid of the given width and height with all cells clear.
+func NewGrid2D(width, height uint) *Grid2D {
+	return &Grid2D{bits: New(width * height), width: width, height: height}
+}
+
+// Width returns the number of columns of the grid.
+func (g *Grid2D) Width() uint {
+	return g.width
+}
+
+// Height returns the number of rows of the grid.
+func (g *Grid2D) Height() uint {
+	return g.height
+}
+
+// BitSet returns the underlying BitSet, of length width*height. It is not
+// a copy: changes to the BitSet are reflected in the grid.
+func (g *Grid2D) BitSet() *BitSet {
+	return g.bits
+}
+
+// InBounds returns true if (x, y) is a cell of the grid.
+func (g *Grid2D) InBounds(x, y uint) bool {
+	return x < g.width && y < g.height
+}
+
+// index maps (x, y) to a bit index, panicking if it is out of bounds
+func (g *Grid2D) index(x, y uint) uint {
+	if !g.InBounds(x, y) {
+		panic(Error("Grid2D: cell out of bounds"))
+	}
+	return y*g.width + x
+}
+
+// Set sets the cell at column x and row y. It panics if the cell is out of bounds.
+func (g *Grid2D) Set(x, y uint) *Grid2D {
+	g.bits.Set(g.index(x, y))
+	return g
+}
+
+// Clear clears the cell at column x and row y. It panics if the cell is out of bounds.
+func (g *Grid2D) Clear(x, y uint) *Grid2D {
+	g.bits.Clear(g.index(x, y))
+	return g
+}
+
+// Test returns true if the cell at column x and row y is set.
+// Cells out of bounds are considered clear.
+func (g *Grid2D) Test(x, y uint) bool {
+	if !g.InBounds(x, y) {
+		return false
+	}
+	return g.bits.Test(y*g.width + x)
+}
+
+// Neighbors returns the number of set cells among the (up to) eight cells
+// adjacent to (x, y), horizontally, vertically or diagonally. Cells out of
+// bounds are considered clear: the grid does not wrap around.
+func (g *Grid2D) Neighbors(x, y uint) uint {
+	var count uint
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			// out of range coordinates wrap around to huge values, which
+			// Test treats as out of bounds
+			if g.Test(x+uint(dx), y+uint(dy)) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// WritePBM writes the grid as a NetPBM bitmap image, see BitSet.WritePBM.
+func (g *Grid2D) WritePBM(w io.Writer) error {
+	return g.bits.WritePBM(w, g.width)
+}
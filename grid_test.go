@@ -0,0 +1,79 @@
+package bitset
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGrid2D(t *testing.T) {
+	g := NewGrid2D(5, 4)
+	if g.Width() != 5 || g.Height() != 4 || g.BitSet().Len() != 20 {
+		t.Fatalf("unexpected dimensions %dx%d (len %d)", g.Width(), g.Height(), g.BitSet().Len())
+	}
+	g.Set(0, 0).Set(1, 0).Set(4, 3).Set(2, 2)
+	if !g.Test(0, 0) || !g.Test(4, 3) || g.Test(3, 3) {
+		t.Error("unexpected cell values")
+	}
+	if !g.BitSet().Test(3*5+4) || !g.BitSet().Test(2*5+2) {
+		t.Error("cells should be stored in row-major order")
+	}
+	// out of bounds cells are clear
+	if g.Test(5, 0) || g.Test(0, 4) || g.Test(^uint(0), 0) {
+		t.Error("cells out of bounds should be clear")
+	}
+	g.Clear(1, 0)
+	if g.Test(1, 0) {
+		t.Error("the cell should be cleared")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("setting a cell out of bounds should panic")
+		}
+	}()
+	g.Set(5, 0)
+}
+
+func TestGrid2DNeighbors(t *testing.T) {
+	// a full 3x3 grid
+	g := NewGrid2D(3, 3)
+	g.BitSet().SetAll()
+	expected := [][]uint{
+		{3, 5, 3},
+		{5, 8, 5},
+		{3, 5, 3},
+	}
+	for y := uint(0); y < 3; y++ {
+		for x := uint(0); x < 3; x++ {
+			if n := g.Neighbors(x, y); n != expected[y][x] {
+				t.Errorf("(%d, %d): expected %d neighbors, got %d", x, y, expected[y][x], n)
+			}
+		}
+	}
+
+	// the grid does not wrap around
+	g = NewGrid2D(4, 4)
+	g.Set(3, 0).Set(0, 3).Set(3, 3)
+	if n := g.Neighbors(0, 0); n != 0 {
+		t.Errorf("expected no neighbor at the corner, got %d", n)
+	}
+	if n := g.Neighbors(3, 1); n != 1 {
+		t.Errorf("expected 1 neighbor at the edge, got %d", n)
+	}
+	if n := g.Neighbors(2, 2); n != 1 {
+		t.Errorf("expected 1 neighbor, got %d", n)
+	}
+}
+
+func TestGrid2DWritePBM(t *testing.T) {
+	g := NewGrid2D(3, 2)
+	g.Set(0, 0).Set(2, 1)
+	var buf bytes.Buffer
+	if err := g.WritePBM(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte("P4\n3 2\n"), 0x80, 0x20)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("expected %q, got %q", expected, buf.Bytes())
+	}
+}
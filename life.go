@@ -0,0 +1,125 @@
+package bitset
+
+// StepLife computes one generation of Conway's Game of Life and returns it
+// as a new BitSet of the same length. The BitSet is interpreted as a
+// two-dimensional grid of the given width in row-major order (as in Grid2D),
+// with (Len()+width-1)/width rows; the last row may be incomplete.
+//
+// Edge behavior: the grid does not wrap around. Cells beyond the edges,
+// including the missing cells of an incomplete last row, are considered
+// dead, and they stay dead.
+//
+// The neighbors are counted for 64 cells at a time: each row is shifted by
+// one cell in both directions, and the eight neighbor rows are added with
+// bit-sliced counters. It panics if width is zero.
+func (b *BitSet) StepLife(width uint) *BitSet {
+	panicIfNull(b)
+	if width == 0 {
+		panic(Error("StepLife: width must be positive"))
+	}
+	result := New(b.length)
+	height := (b.length + width - 1) / width
+	n := int((width + wordMask) >> log2WordSize) // words per row
+	lastMask := allBits
+	if wordsIndex(width) != 0 {
+		lastMask = allBits >> (wordSize - wordsIndex(width))
+	}
+
+	rows := [3][]uint64{make([]uint64, n), make([]uint64, n), make([]uint64, n)}
+	left, right := make([]uint64, n), make([]uint64, n)
+	next := make([]uint64, n)
+	// s0, s1 and s2 hold, for each cell, the number of live neighbors
+	// modulo 8 (8 neighbors wraps to 0, which is fine since only 2 and 3 matter)
+	s0, s1, s2 := make([]uint64, n), make([]uint64, n), make([]uint64, n)
+	b.loadRow(rows[1], 0, width, height, lastMask)
+	for y := uint(0); y < height; y++ {
+		// rows[0] is the row above, rows[1] the current row and rows[2] the row below
+		b.loadRow(rows[2], y+1, width, height, lastMask)
+
+		for k := range next {
+			s0[k], s1[k], s2[k] = 0, 0, 0
+		}
+		for r, row := range rows {
+			shiftRowLeft(left, row, lastMask)
+			shiftRowRight(right, row)
+			for k := range next {
+				s0[k], s1[k], s2[k] = addBitSliced(s0[k], s1[k], s2[k], left[k])
+				s0[k], s1[k], s2[k] = addBitSliced(s0[k], s1[k], s2[k], right[k])
+				if r != 1 {
+					s0[k], s1[k], s2[k] = addBitSliced(s0[k], s1[k], s2[k], row[k])
+				}
+			}
+		}
+		for k := range next {
+			two := s1[k] &^ s2[k] &^ s0[k]
+			three := s1[k] &^ s2[k] & s0[k]
+			next[k] = three | (two & rows[1][k])
+		}
+		result.orRow(next, y*width)
+
+		rows[0], rows[1], rows[2] = rows[1], rows[2], rows[0]
+	}
+	result.cleanLastWord()
+	return result
+}
+
+// addBitSliced adds the bits of a to the 3-bit counters (s0, s1, s2),
+// where s0 holds the least significant bits, modulo 8.
+func addBitSliced(s0, s1, s2, a uint64) (uint64, uint64, uint64) {
+	c0 := s0 & a
+	c1 := s1 & c0
+	return s0 ^ a, s1 ^ c0, s2 ^ c1
+}
+
+// loadRow copies row y of a grid of the given width and height into dst,
+// or clears dst if y is beyond the last row.
+func (b *BitSet) loadRow(dst []uint64, y, width, height uint, lastMask uint64) {
+	if y >= height {
+		for k := range dst {
+			dst[k] = 0
+		}
+		return
+	}
+	for k := range dst {
+		dst[k] = b.GetWord64AtBit(y*width + uint(k)<<log2WordSize)
+	}
+	dst[len(dst)-1] &= lastMask
+}
+
+// orRow ors the bits of src into the BitSet, starting at bit offset.
+// The bits that fall beyond the backing slice are dropped.
+func (b *BitSet) orRow(src []uint64, offset uint) {
+	for k, w := range src {
+		pos := offset + uint(k)<<log2WordSize
+		idx, off := int(pos>>log2WordSize), wordsIndex(pos)
+		if idx >= len(b.set) {
+			return
+		}
+		b.set[idx] |= w << off
+		if off != 0 && idx+1 < len(b.set) {
+			b.set[idx+1] |= w >> (wordSize - off)
+		}
+	}
+}
+
+// shiftRowLeft sets dst to the row src shifted by one cell towards higher
+// indexes, so that each cell of dst holds its left neighbor in src.
+func shiftRowLeft(dst, src []uint64, lastMask uint64) {
+	var carry uint64
+	for k, w := range src {
+		dst[k] = w<<1 | carry
+		carry = w >> wordMask
+	}
+	dst[len(dst)-1] &= lastMask
+}
+
+// shiftRowRight sets dst to the row src shifted by one cell towards lower
+// indexes, so that each cell of dst holds its right neighbor in src.
+func shiftRowRight(dst, src []uint64) {
+	for k, w := range src {
+		dst[k] = w >> 1
+		if k+1 < len(src) {
+			dst[k] |= src[k+1] << wordMask
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// stepLifeNaive is a reference implementation of StepLife, cell by cell.
+func stepLifeNaive(b *BitSet, width uint) *BitSet {
+	result := New(b.Len())
+	height := (b.Len() + width - 1) / width
+	alive := func(x, y int) bool {
+		if x < 0 || y < 0 || uint(x) >= width || uint(y) >= height {
+			return false
+		}
+		return b.Test(uint(y)*width + uint(x))
+	}
+	for i := uint(0); i < b.Len(); i++ {
+		x, y := int(i%width), int(i/width)
+		count := 0
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if (dx != 0 || dy != 0) && alive(x+dx, y+dy) {
+					count++
+				}
+			}
+		}
+		if count == 3 || (count == 2 && b.Test(i)) {
+			result.Set(i)
+		}
+	}
+	return result
+}
+
+func TestStepLife(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for _, width := range []uint{1, 3, 8, 63, 64, 65, 100, 130} {
+		for _, height := range []uint{1, 2, 5, 9} {
+			for _, missing := range []uint{0, 1} {
+				length := width*height - missing
+				if length == 0 {
+					continue
+				}
+				b := New(length)
+				for i := uint(0); i < length; i++ {
+					if r.Intn(3) == 0 {
+						b.Set(i)
+					}
+				}
+				for gen := 0; gen < 3; gen++ {
+					expected := stepLifeNaive(b, width)
+					got := b.StepLife(width)
+					if !got.Equal(expected) {
+						t.Fatalf("%dx%d (len %d), generation %d: expected %v, got %v", width, height, length, gen, expected, got)
+					}
+					if err := got.CheckInvariants(); err != nil {
+						t.Fatal(err)
+					}
+					b = got
+				}
+			}
+		}
+	}
+}
+
+func TestStepLifeNoWrap(t *testing.T) {
+	// a vertical blinker on the left edge: with wrapping, the cells of the
+	// right edge would count it as neighbors
+	g := NewGrid2D(5, 5)
+	g.Set(0, 1).Set(0, 2).Set(0, 3)
+	next := g.BitSet().StepLife(5)
+	expected := NewGrid2D(5, 5)
+	expected.Set(0, 2).Set(1, 2)
+	if !next.Equal(expected.BitSet()) {
+		t.Errorf("expected %v, got %v", expected.BitSet(), next)
+	}
+
+	// a blinker turns back
+	blinker := NewGrid2D(5, 5)
+	blinker.Set(1, 2).Set(2, 2).Set(3, 2)
+	if !blinker.BitSet().StepLife(5).StepLife(5).Equal(blinker.BitSet()) {
+		t.Error("a blinker should have a period of 2")
+	}
+}
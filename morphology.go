@@ -0,0 +1,102 @@
+package bitset
+
+// Dilate returns a new BitSet, of the same length, where a bit is set if
+// and only if there is a set bit within distance n of it in the BitSet:
+// every run of set bits grows by up to n bits on each side.
+// This is the morphological dilation with a window of 2n+1 bits.
+func (b *BitSet) Dilate(n uint) *BitSet {
+	panicIfNull(b)
+	return b.morph(n, false)
+}
+
+// Erode returns a new BitSet, of the same length, where a bit is set if
+// and only if all bits within distance n of it are set in the BitSet:
+// every run of set bits shrinks by n bits on each side, and runs shorter
+// than 2n+1 bits disappear. Bits beyond the boundaries of the BitSet are
+// considered set, so that runs touching the boundaries are not eroded on
+// that side; with this convention, Erode is the dual of Dilate and,
+// for instance, Dilate(n).Erode(n) leaves a set of runs separated by
+// gaps of more than 2n bits unchanged.
+// This is the morphological erosion with a window of 2n+1 bits.
+func (b *BitSet) Erode(n uint) *BitSet {
+	panicIfNull(b)
+	return b.morph(n, true)
+}
+
+// morph implements Dilate (erode is false) and Erode (erode is true) by
+// combining shifted copies, doubling the covered distance at each step.
+func (b *BitSet) morph(n uint, erode bool) *BitSet {
+	result := b.Clone()
+	if n > b.length {
+		n = b.length
+	}
+	words := result.set
+	up, down := make([]uint64, len(words)), make([]uint64, len(words))
+	for covered := uint(0); covered < n; {
+		// shifting by at most covered+1 leaves no gap
+		s := covered + 1
+		if s > n-covered {
+			s = n - covered
+		}
+		result.shiftedWords(up, s, true, erode)
+		result.shiftedWords(down, s, false, erode)
+		for k := range words {
+			if erode {
+				words[k] &= up[k] & down[k]
+			} else {
+				words[k] |= up[k] | down[k]
+			}
+		}
+		covered += s
+	}
+	if len(words) > 0 {
+		result.cleanLastWord()
+	}
+	return result
+}
+
+// shiftedWords sets dst to the words of the BitSet shifted by s bits,
+// towards higher indexes if up is true, or towards lower indexes otherwise.
+// The bits shifted in, from beyond the boundaries of the BitSet, are set
+// if fill is true and clear otherwise. The bits of dst beyond the length
+// are not meaningful.
+func (b *BitSet) shiftedWords(dst []uint64, s uint, up, fill bool) {
+	var f uint64
+	if fill {
+		f = allBits
+	}
+	n := len(dst)
+	if s >= b.length {
+		for k := range dst {
+			dst[k] = f
+		}
+		return
+	}
+	var high uint64 // the bits beyond the length in the last word
+	if !b.isLenExactMultiple() {
+		high = allBits << wordsIndex(b.length)
+	}
+	get := func(j int) uint64 {
+		if j < 0 || j >= n {
+			return f
+		}
+		if j == n-1 {
+			return b.set[j]&^high | f&high
+		}
+		return b.set[j]
+	}
+	pages, shift := int(s>>log2WordSize), wordsIndex(s)
+	for k := range dst {
+		if up {
+			dst[k] = get(k-pages) << shift
+			if shift != 0 {
+				dst[k] |= get(k-pages-1) >> (wordSize - shift)
+			}
+		} else {
+			dst[k] = get(k+pages) >> shift
+			if shift != 0 {
+				dst[k] |= get(k+pages+1) << (wordSize - shift)
+			}
+		}
+	}
+}
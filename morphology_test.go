@@ -0,0 +1,80 @@
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// morphNaive is a reference implementation of Dilate and Erode, bit by bit.
+func morphNaive(b *BitSet, n uint, erode bool) *BitSet {
+	result := New(b.Len())
+	for i := uint(0); i < b.Len(); i++ {
+		some, all := false, true
+		for j := int(i) - int(n); j <= int(i)+int(n); j++ {
+			var v bool
+			if j < 0 || uint(j) >= b.Len() {
+				v = erode // beyond the boundaries
+			} else {
+				v = b.Test(uint(j))
+			}
+			some = some || v
+			all = all && v
+		}
+		if (erode && all) || (!erode && some) {
+			result.Set(i)
+		}
+	}
+	return result
+}
+
+func TestDilateErode(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	for _, length := range []uint{0, 1, 10, 63, 64, 65, 130, 300} {
+		b := New(length)
+		for i := uint(0); i < length; i++ {
+			if r.Intn(4) != 0 {
+				b.Set(i)
+			}
+		}
+		for _, n := range []uint{0, 1, 2, 3, 7, 63, 64, 65, 100, 1000} {
+			if got, expected := b.Dilate(n), morphNaive(b, n, false); !got.Equal(expected) {
+				t.Errorf("Dilate(%d), length %d: expected %v, got %v", n, length, expected, got)
+			}
+			if got, expected := b.Erode(n), morphNaive(b, n, true); !got.Equal(expected) {
+				t.Errorf("Erode(%d), length %d: expected %v, got %v", n, length, expected, got)
+			}
+			if err := b.Dilate(n).CheckInvariants(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+func TestDilateErodeRuns(t *testing.T) {
+	// runs separated by gaps wider than 2n are unchanged by a closing
+	b := New(300)
+	b.FlipRange(0, 10).FlipRange(50, 120).FlipRange(150, 151).FlipRange(200, 300)
+	for _, n := range []uint{1, 5, 9} {
+		closed := b.Dilate(n).Erode(n)
+		if !closed.Equal(b) {
+			t.Errorf("n=%d: expected %v, got %v", n, b, closed)
+		}
+	}
+
+	// runs grow and shrink as expected
+	c := New(100)
+	c.FlipRange(40, 60)
+	if got := c.Dilate(5); got.Count() != 30 || !got.Test(35) || !got.Test(64) || got.Test(65) {
+		t.Errorf("unexpected dilation %v", got)
+	}
+	if got := c.Erode(5); got.Count() != 10 || !got.Test(45) || !got.Test(54) || got.Test(55) {
+		t.Errorf("unexpected erosion %v", got)
+	}
+	if got := c.Erode(10); got.Any() {
+		t.Errorf("a run of 20 bits should vanish with n=10, got %v", got)
+	}
+	// the original is not modified
+	if c.Count() != 20 {
+		t.Error("Dilate and Erode should not modify the BitSet")
+	}
+}
@@ -0,0 +1,70 @@
+package bitset
+
+// InterleaveWith returns the Morton code (Z-order) interleaving the bits of
+// b and other: bit i of b becomes bit 2i of the result, and bit i of other
+// becomes bit 2i+1. The shorter BitSet is zero-extended, and the length of
+// the result is twice the maximum of the two lengths. See Deinterleave for
+// the inverse.
+func (b *BitSet) InterleaveWith(other *BitSet) *BitSet {
+	panicIfNull(b)
+	panicIfNull(other)
+	length := b.length
+	if other.length > length {
+		length = other.length
+	}
+	if length > Cap()/2 {
+		panic("You are exceeding the capacity")
+	}
+	result := New(2 * length)
+	aw, bw := b.usedWords(), other.usedWords()
+	for i := range result.set {
+		// the word i of the result holds the half i%2 of the words i/2
+		shift := uint(i&1) * 32
+		x, y := wordAt(aw, i>>1)>>shift, wordAt(bw, i>>1)>>shift
+		result.set[i] = spreadBits(x) | spreadBits(y)<<1
+	}
+	return result
+}
+
+// Deinterleave is the inverse of InterleaveWith: it returns the BitSet of
+// the even bits of b, with bit 2i of b at index i, and the BitSet of the
+// odd bits, with bit 2i+1 of b at index i. Their lengths are
+// (Len()+1)/2 and Len()/2 respectively.
+func (b *BitSet) Deinterleave() (even, odd *BitSet) {
+	panicIfNull(b)
+	even, odd = New((b.length+1)/2), New(b.length/2)
+	words := b.usedWords()
+	for i := 0; i < len(words); i += 2 {
+		lo, hi := words[i], wordAt(words, i+1)
+		j := i >> 1
+		if j < len(even.set) {
+			even.set[j] = compactBits(lo) | compactBits(hi)<<32
+		}
+		if j < len(odd.set) {
+			odd.set[j] = compactBits(lo>>1) | compactBits(hi>>1)<<32
+		}
+	}
+	return even, odd
+}
+
+// spreadBits moves the bit k of the low 32 bits of x to bit 2k.
+func spreadBits(x uint64) uint64 {
+	x &= 0x00000000ffffffff
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// compactBits moves the bit 2k of x to bit k, it is the inverse of spreadBits.
+func compactBits(x uint64) uint64 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return x
+}
@@ -0,0 +1,65 @@
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestInterleaveWith(t *testing.T) {
+	r := rand.New(rand.NewSource(18))
+	for _, lengths := range [][2]uint{{0, 0}, {1, 1}, {5, 3}, {32, 33}, {64, 64}, {100, 10}, {7, 300}} {
+		a, b := New(lengths[0]), New(lengths[1])
+		for i := uint(0); i < lengths[0]; i++ {
+			if r.Intn(2) == 0 {
+				a.Set(i)
+			}
+		}
+		for i := uint(0); i < lengths[1]; i++ {
+			if r.Intn(2) == 0 {
+				b.Set(i)
+			}
+		}
+		z := a.InterleaveWith(b)
+		length := lengths[0]
+		if lengths[1] > length {
+			length = lengths[1]
+		}
+		if z.Len() != 2*length {
+			t.Errorf("expected a length of %d, got %d", 2*length, z.Len())
+		}
+		for i := uint(0); i < length; i++ {
+			if z.Test(2*i) != a.Test(i) || z.Test(2*i+1) != b.Test(i) {
+				t.Fatalf("lengths %v: bits %d and %d are not interleaved", lengths, 2*i, 2*i+1)
+			}
+		}
+		if err := z.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+
+		even, odd := z.Deinterleave()
+		if even.Len() != length || odd.Len() != length {
+			t.Errorf("unexpected lengths %d and %d", even.Len(), odd.Len())
+		}
+		if even.Count() != a.Count() || even.IntersectionCardinality(a) != a.Count() ||
+			odd.Count() != b.Count() || odd.IntersectionCardinality(b) != b.Count() {
+			t.Errorf("lengths %v: Deinterleave should recover the originals", lengths)
+		}
+	}
+
+	// a small Morton code: x = 0b011, y = 0b101
+	z := New(3).Set(0).Set(1).InterleaveWith(New(3).Set(0).Set(2))
+	if z.Len() != 6 || z.Count() != 4 || !z.Test(0) || !z.Test(1) || !z.Test(2) || !z.Test(5) {
+		t.Errorf("unexpected Morton code %v", z)
+	}
+}
+
+func TestDeinterleaveOddLength(t *testing.T) {
+	b := New(5).Set(0).Set(3).Set(4)
+	even, odd := b.Deinterleave()
+	if even.Len() != 3 || odd.Len() != 2 {
+		t.Errorf("unexpected lengths %d and %d", even.Len(), odd.Len())
+	}
+	if !even.Equal(New(3).Set(0).Set(2)) || !odd.Equal(New(2).Set(1)) {
+		t.Errorf("unexpected result %v and %v", even, odd)
+	}
+}
@@ -0,0 +1,63 @@
+package bitset
+
+// PackedArray is an array of unsigned integers of a fixed width in bits,
+// stored contiguously in a BitSet: lane i occupies the bits i*width to
+// i*width+width-1, with its least significant bit first, so that a lane
+// may straddle two words. It is a compact storage for small integers,
+// such as the columns of a table.
+type PackedArray struct {
+	bits  *BitSet
+	width uint
+	mask  uint64
+	n     uint
+}
+
+// NewPackedArray creates an array of n lanes of the given width in bits,
+// all 0. It panics if width is not between 1 and 64.
+func NewPackedArray(n, width uint) *PackedArray {
+	if width == 0 || width > wordSize {
+		panic(Error("NewPackedArray: width must be between 1 and 64"))
+	}
+	if n > Cap()/width {
+		panic("You are exceeding the capacity")
+	}
+	return &PackedArray{bits: New(n * width), width: width, mask: allBits >> (wordSize - width), n: n}
+}
+
+// Len returns the number of lanes of the array.
+func (p *PackedArray) Len() uint {
+	return p.n
+}
+
+// Width returns the width of the lanes, in bits.
+func (p *PackedArray) Width() uint {
+	return p.width
+}
+
+// BitSet returns the underlying BitSet. It is not a copy: changes to the
+// BitSet are reflected in the array.
+func (p *PackedArray) BitSet() *BitSet {
+	return p.bits
+}
+
+// GetLane returns the value of lane i. It panics if i >= Len().
+func (p *PackedArray) GetLane(i uint) uint64 {
+	if i >= p.n {
+		panic(Error("PackedArray: lane out of range"))
+	}
+	return p.bits.GetWord64AtBit(i*p.width) & p.mask
+}
+
+// SetLane sets the value of lane i to v. It panics if i >= Len(), or
+// if v does not fit in Width() bits.
+func (p *PackedArray) SetLane(i uint, v uint64) *PackedArray {
+	if i >= p.n {
+		panic(Error("PackedArray: lane out of range"))
+	}
+	if v&^p.mask != 0 {
+		panic(Error("PackedArray: value does not fit in the lane width"))
+	}
+	p.bits.prepareWrite()
+	p.bits.putBits(i*p.width, v, p.width)
+	return p
+}
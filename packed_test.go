@@ -0,0 +1,60 @@
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPackedArray(t *testing.T) {
+	r := rand.New(rand.NewSource(22))
+	for _, width := range []uint{1, 3, 7, 13, 32, 33, 63, 64} {
+		const n = 200
+		p := NewPackedArray(n, width)
+		if p.Len() != n || p.Width() != width || p.BitSet().Len() != n*width {
+			t.Fatalf("width %d: unexpected sizes", width)
+		}
+		expected := make([]uint64, n)
+		for k := 0; k < 3*n; k++ {
+			i := uint(r.Intn(n))
+			v := r.Uint64() & (allBits >> (64 - width))
+			p.SetLane(i, v)
+			expected[i] = v
+		}
+		for i := uint(0); i < n; i++ {
+			if got := p.GetLane(i); got != expected[i] {
+				t.Fatalf("width %d: lane %d = %x; expected %x", width, i, got, expected[i])
+			}
+		}
+		if err := p.BitSet().CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// a lane straddling two words, surrounded by other lanes
+	p := NewPackedArray(10, 13) // lane 4 is in bits 52 to 64
+	p.SetLane(3, 0x1fff).SetLane(5, 0x1fff).SetLane(4, 0x1555)
+	if p.GetLane(3) != 0x1fff || p.GetLane(4) != 0x1555 || p.GetLane(5) != 0x1fff {
+		t.Errorf("unexpected lanes %x %x %x", p.GetLane(3), p.GetLane(4), p.GetLane(5))
+	}
+	p.SetLane(4, 0)
+	if p.GetLane(3) != 0x1fff || p.GetLane(4) != 0 || p.GetLane(5) != 0x1fff {
+		t.Errorf("unexpected lanes %x %x %x", p.GetLane(3), p.GetLane(4), p.GetLane(5))
+	}
+
+	for _, f := range []func(){
+		func() { NewPackedArray(10, 0) },
+		func() { NewPackedArray(10, 65) },
+		func() { p.SetLane(0, 1<<13) },
+		func() { p.SetLane(10, 0) },
+		func() { p.GetLane(10) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,162 @@
+package bitset
+
+import "sync"
+
+// parallelThreshold is the number of words under which the parallel
+// functions fall back to a serial computation: for smaller sets, the
+// cost of starting goroutines exceeds the benefits.
+const parallelThreshold = 1 << 14
+
+// parallelChunks splits the range of n words into at most workers
+// contiguous chunks and calls fn(start, end) for each chunk in its own
+// goroutine. It returns once all calls have completed.
+func parallelChunks(n, workers int, fn func(start, end int)) {
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += chunk {
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			fn(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// CountParallel returns the number of set bits, like Count, but it
+// splits the work among up to workers goroutines. This is only beneficial
+// for very large sets: when workers <= 1 or when the set is small,
+// it falls back to Count.
+func (b *BitSet) CountParallel(workers int) uint {
+	if b == nil || workers <= 1 || len(b.set) < parallelThreshold {
+		return b.Count()
+	}
+	counts := make([]uint64, workers)
+	chunk := (len(b.set) + workers - 1) / workers
+	parallelChunks(len(b.set), workers, func(start, end int) {
+		counts[start/chunk] = popcntSlice(b.set[start:end])
+	})
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	return uint(total)
+}
+
+// parallelApply calls fn on matching chunks of dst and src, which must
+// have the same length, using up to workers goroutines.
+func parallelApply(dst, src []uint64, workers int, fn func(dst, src []uint64)) {
+	parallelChunks(len(dst), workers, func(start, end int) {
+		fn(dst[start:end], src[start:end])
+	})
+}
+
+// serialParallel reports whether a parallel operation between b and
+// compare should fall back to the serial implementation.
+func serialParallel(b, compare *BitSet, workers int) bool {
+	return workers <= 1 || (len(b.set) < parallelThreshold && len(compare.set) < parallelThreshold)
+}
+
+// InPlaceUnionParallel computes the same result as InPlaceUnion, but it
+// splits the work among up to workers goroutines. This is only beneficial
+// for very large sets: when workers <= 1 or when the sets are small,
+// it falls back to InPlaceUnion.
+func (b *BitSet) InPlaceUnionParallel(compare *BitSet, workers int) {
+	panicIfNull(b)
+	b.prepareWrite()
+	panicIfNull(compare)
+	if serialParallel(b, compare, workers) {
+		b.InPlaceUnion(compare)
+		return
+	}
+	if compare.length > 0 && compare.length-1 >= b.length {
+		b.extendSet(compare.length - 1)
+	}
+	src := compare.usedWords()
+	parallelApply(b.set[:len(src)], src, workers, func(dst, src []uint64) {
+		for i := range dst {
+			dst[i] |= src[i]
+		}
+	})
+}
+
+// InPlaceIntersectionParallel computes the same result as InPlaceIntersection,
+// but it splits the work among up to workers goroutines. This is only
+// beneficial for very large sets: when workers <= 1 or when the sets are
+// small, it falls back to InPlaceIntersection.
+func (b *BitSet) InPlaceIntersectionParallel(compare *BitSet, workers int) {
+	panicIfNull(b)
+	b.prepareWrite()
+	panicIfNull(compare)
+	if serialParallel(b, compare, workers) {
+		b.InPlaceIntersection(compare)
+		return
+	}
+	src := compare.usedWords()
+	l := len(src)
+	if l > len(b.set) {
+		l = len(b.set)
+	}
+	parallelApply(b.set[:l], src[:l], workers, func(dst, src []uint64) {
+		for i := range dst {
+			dst[i] &= src[i]
+		}
+	})
+	for i := l; i < len(b.set); i++ {
+		b.set[i] = 0
+	}
+	if compare.length > 0 && compare.length-1 >= b.length {
+		b.extendSet(compare.length - 1)
+	}
+}
+
+// InPlaceDifferenceParallel computes the same result as InPlaceDifference,
+// but it splits the work among up to workers goroutines. This is only
+// beneficial for very large sets: when workers <= 1 or when the sets are
+// small, it falls back to InPlaceDifference.
+func (b *BitSet) InPlaceDifferenceParallel(compare *BitSet, workers int) {
+	panicIfNull(b)
+	b.prepareWrite()
+	panicIfNull(compare)
+	if serialParallel(b, compare, workers) {
+		b.InPlaceDifference(compare)
+		return
+	}
+	src := compare.usedWords()
+	l := len(src)
+	if l > len(b.set) {
+		l = len(b.set)
+	}
+	parallelApply(b.set[:l], src[:l], workers, func(dst, src []uint64) {
+		for i := range dst {
+			dst[i] &^= src[i]
+		}
+	})
+}
+
+// InPlaceSymmetricDifferenceParallel computes the same result as
+// InPlaceSymmetricDifference, but it splits the work among up to workers
+// goroutines. This is only beneficial for very large sets: when workers <= 1
+// or when the sets are small, it falls back to InPlaceSymmetricDifference.
+func (b *BitSet) InPlaceSymmetricDifferenceParallel(compare *BitSet, workers int) {
+	panicIfNull(b)
+	b.prepareWrite()
+	panicIfNull(compare)
+	if serialParallel(b, compare, workers) {
+		b.InPlaceSymmetricDifference(compare)
+		return
+	}
+	if compare.length > 0 && compare.length-1 >= b.length {
+		b.extendSet(compare.length - 1)
+	}
+	src := compare.usedWords()
+	parallelApply(b.set[:len(src)], src, workers, func(dst, src []uint64) {
+		for i := range dst {
+			dst[i] ^= src[i]
+		}
+	})
+}
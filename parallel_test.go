@@ -0,0 +1,64 @@
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCountParallel(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, length := range []uint{0, 100, parallelThreshold * 64, parallelThreshold*64*3 + 17} {
+		b := New(length)
+		for i := uint(0); i < length/3; i++ {
+			b.Set(uint(r.Int63n(int64(length))))
+		}
+		for _, workers := range []int{-1, 0, 1, 2, 3, 7, 16} {
+			if got := b.CountParallel(workers); got != b.Count() {
+				t.Errorf("length %d, %d workers: expected %d, got %d", length, workers, b.Count(), got)
+			}
+		}
+	}
+	var null *BitSet
+	if null.CountParallel(4) != 0 {
+		t.Error("a nil BitSet should have a count of 0")
+	}
+}
+
+func TestInPlaceOperationsParallel(t *testing.T) {
+	type op struct {
+		name     string
+		serial   func(b, compare *BitSet)
+		parallel func(b, compare *BitSet, workers int)
+	}
+	ops := []op{
+		{"Union", (*BitSet).InPlaceUnion, (*BitSet).InPlaceUnionParallel},
+		{"Intersection", (*BitSet).InPlaceIntersection, (*BitSet).InPlaceIntersectionParallel},
+		{"Difference", (*BitSet).InPlaceDifference, (*BitSet).InPlaceDifferenceParallel},
+		{"SymmetricDifference", (*BitSet).InPlaceSymmetricDifference, (*BitSet).InPlaceSymmetricDifferenceParallel},
+	}
+	r := rand.New(rand.NewSource(2))
+	random := func(length uint) *BitSet {
+		b := New(length)
+		for i := uint(0); i < length/4; i++ {
+			b.Set(uint(r.Int63n(int64(length))))
+		}
+		return b
+	}
+	large := uint(parallelThreshold * 64 * 2)
+	for _, o := range ops {
+		for _, la := range []uint{100, large, large + 1000} {
+			for _, lb := range []uint{100, large, large + 1000} {
+				a, b := random(la), random(lb)
+				expected := a.Clone()
+				o.serial(expected, b)
+				for _, workers := range []int{1, 2, 5} {
+					c := a.Clone()
+					o.parallel(c, b, workers)
+					if !c.Equal(expected) {
+						t.Errorf("%s(%d, %d) with %d workers does not match the serial result", o.name, la, lb, workers)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package bitset
+
+import "sync"
+
+// Pool is a set of BitSets that may be individually saved and retrieved,
+// so that the memory of discarded BitSets can be reused. It is a thin
+// wrapper around sync.Pool and it is safe for concurrent use.
+// The zero value of a Pool is ready to use.
+//
+//	var pool bitset.Pool
+//	b := pool.Get()
+//	b.Set(10)
+//	...
+//	pool.Put(b)
+type Pool struct {
+	pool sync.Pool
+}
+
+// Get selects an arbitrary BitSet from the Pool, removes it from the Pool
+// and returns it. The returned BitSet is empty (its length is 0), but
+// it may retain the memory from a previous use.
+// If the Pool is empty, Get returns a new BitSet.
+func (p *Pool) Get() *BitSet {
+	if b, ok := p.pool.Get().(*BitSet); ok {
+		return b
+	}
+	return New(0)
+}
+
+// Put resets the BitSet (see [BitSet.Reset]) and adds it to the Pool.
+// The caller must not use the BitSet after calling Put.
+func (p *Pool) Put(b *BitSet) {
+	if b == nil {
+		return
+	}
+	p.pool.Put(b.Reset())
+}
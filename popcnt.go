@@ -3,11 +3,23 @@ package bitset
 import "math/bits"
 
 func popcntSlice(s []uint64) uint64 {
-	var cnt int
+	// We process the words in blocks of 8 (one 64-byte cache line) using
+	// independent accumulators so that the popcounts are not serialized
+	// on a single running sum.
+	var cnt0, cnt1, cnt2, cnt3 int
+	for len(s) >= 8 {
+		// this explicit re-slicing eliminates the bounds checks below
+		w := s[:8:8]
+		cnt0 += bits.OnesCount64(w[0]) + bits.OnesCount64(w[4])
+		cnt1 += bits.OnesCount64(w[1]) + bits.OnesCount64(w[5])
+		cnt2 += bits.OnesCount64(w[2]) + bits.OnesCount64(w[6])
+		cnt3 += bits.OnesCount64(w[3]) + bits.OnesCount64(w[7])
+		s = s[8:]
+	}
 	for _, x := range s {
-		cnt += bits.OnesCount64(x)
+		cnt0 += bits.OnesCount64(x)
 	}
-	return uint64(cnt)
+	return uint64(cnt0 + cnt1 + cnt2 + cnt3)
 }
 
 func popcntMaskSlice(s, m []uint64) uint64 {
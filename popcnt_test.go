@@ -3,6 +3,7 @@
 package bitset
 
 import (
+	"math/bits"
 	"testing"
 )
 
@@ -15,6 +16,20 @@ func TestPopcntSlice(t *testing.T) {
 	}
 }
 
+func TestPopcntSliceUnrolled(t *testing.T) {
+	// exercise every combination of full 8-word blocks and leftover words
+	s := make([]uint64, 0, 40)
+	var expected uint64
+	for i := 0; i < 40; i++ {
+		if res := popcntSlice(s); res != expected {
+			t.Errorf("Wrong popcount for %d words: %d != %d", len(s), res, expected)
+		}
+		w := uint64(0x9e3779b97f4a7c15) * uint64(i+1)
+		s = append(s, w)
+		expected += uint64(bits.OnesCount64(w))
+	}
+}
+
 func TestPopcntMaskSlice(t *testing.T) {
 	s := []uint64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29}
 	m := []uint64{31, 37, 41, 43, 47, 53, 59, 61, 67, 71}
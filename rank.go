@@ -0,0 +1,130 @@
+package bitset
+
+import "math/bits"
+
+// wordsPerSuperblock is the number of words in a superblock of a RankIndex
+const wordsPerSuperblock = 8
+
+// RankIndex accelerates Rank queries on a BitSet that is not modified.
+// It stores the cumulative number of set bits before each superblock of
+// 512 bits, and the number of set bits before each word within its
+// superblock, so that a query takes constant time. For each superblock of
+// 64 bytes, it uses 8 bytes for the cumulative count and 16 bytes for the
+// counts of the words: about 37.5% of the memory of the BitSet.
+//
+// The index refers to the words of the BitSet: it must be rebuilt (see
+// BitSet.BuildRankIndex) after the BitSet is modified.
+type RankIndex struct {
+	set    []uint64
+	length uint
+	super  []uint64 // set bits before each superblock
+	local  []uint16 // set bits before each word, within its superblock
+}
+
+// BuildRankIndex builds a RankIndex for the current content of the BitSet.
+func (b *BitSet) BuildRankIndex() *RankIndex {
+	panicIfNull(b)
+	words := b.usedWords()
+	r := &RankIndex{
+		set:    words,
+		length: b.length,
+		super:  make([]uint64, (len(words)+wordsPerSuperblock-1)/wordsPerSuperblock+1),
+		local:  make([]uint16, len(words)),
+	}
+	var total uint64
+	var inSuper uint16
+	for i, w := range words {
+		if i%wordsPerSuperblock == 0 {
+			r.super[i/wordsPerSuperblock] = total
+			inSuper = 0
+		}
+		r.local[i] = inSuper
+		c := uint16(bits.OnesCount64(w))
+		inSuper += c
+		total += uint64(c)
+	}
+	r.super[len(r.super)-1] = total
+	return r
+}
+
+// Count returns the number of set bits in the indexed BitSet.
+func (r *RankIndex) Count() uint {
+	return uint(r.super[len(r.super)-1])
+}
+
+// Rank returns the number of set bits up to and including the index,
+// like BitSet.Rank, in constant time.
+func (r *RankIndex) Rank(index uint) uint {
+	if index >= r.length {
+		return r.Count()
+	}
+	w := int(index >> log2WordSize)
+	answer := r.super[w/wordsPerSuperblock] + uint64(r.local[w])
+	// count the bits of the word up to and including index
+	answer += uint64(bits.OnesCount64(r.set[w] << (wordMask - wordsIndex(index))))
+	return uint(answer)
+}
+
+// DefaultSelectSampling is the sampling rate used by BuildSelectIndex.
+const DefaultSelectSampling = 256
+
+// SelectIndex accelerates Select queries on a BitSet that is not modified.
+// It stores the position of every kth set bit, where k is the sampling rate,
+// so that a query only scans from the nearest sample: Select(j) starts at
+// the position of the (j/k*k)th set bit and skips j%k set bits, word by word.
+//
+// The sampling rate is a space/time tradeoff: the index uses one word
+// (8 bytes) per k set bits, and a query scans about k/density bits, where
+// density is the fraction of set bits. Smaller values of k give faster
+// queries and larger indexes.
+//
+// The index refers to the words of the BitSet: it must be rebuilt
+// after the BitSet is modified.
+type SelectIndex struct {
+	bitset  BitSet
+	k       uint
+	count   uint
+	samples []uint // position of the set bits of rank 0, k, 2k, ...
+}
+
+// BuildSelectIndex builds a SelectIndex for the current content of the BitSet,
+// with the sampling rate DefaultSelectSampling.
+func (b *BitSet) BuildSelectIndex() *SelectIndex {
+	return b.BuildSelectIndexSampled(DefaultSelectSampling)
+}
+
+// BuildSelectIndexSampled builds a SelectIndex for the current content of the
+// BitSet, storing the position of every kth set bit (see SelectIndex).
+// A value of k equal to 0 is treated as 1.
+func (b *BitSet) BuildSelectIndexSampled(k uint) *SelectIndex {
+	panicIfNull(b)
+	if k == 0 {
+		k = 1
+	}
+	s := &SelectIndex{bitset: BitSet{length: b.length, set: b.usedWords()}, k: k}
+	var rank uint
+	for idx, word := range s.bitset.set {
+		c := uint(bits.OnesCount64(word))
+		// the next sample has rank len(samples)*k, it may be in this word
+		for next := uint(len(s.samples)) * k; next < rank+c; next += k {
+			s.samples = append(s.samples, uint(idx)<<log2WordSize+select64(word, next-rank))
+		}
+		rank += c
+	}
+	s.count = rank
+	return s
+}
+
+// Count returns the number of set bits in the indexed BitSet.
+func (s *SelectIndex) Count() uint {
+	return s.count
+}
+
+// Select returns the index of the jth set bit, like BitSet.Select, along
+// with true. It returns false when j >= Count().
+func (s *SelectIndex) Select(j uint) (uint, bool) {
+	if j >= s.count {
+		return 0, false
+	}
+	return s.bitset.NextSetN(s.samples[j/s.k], j%s.k)
+}
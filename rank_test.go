@@ -0,0 +1,78 @@
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRankIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	for _, length := range []uint{0, 1, 64, 511, 512, 513, 2000, 5000} {
+		b := New(length)
+		for i := uint(0); i < length; i++ {
+			if r.Intn(3) == 0 {
+				b.Set(i)
+			}
+		}
+		idx := b.BuildRankIndex()
+		if idx.Count() != b.Count() {
+			t.Errorf("length %d: expected count %d, got %d", length, b.Count(), idx.Count())
+		}
+		for i := uint(0); i < length+70; i++ {
+			if got, expected := idx.Rank(i), b.Rank(i); got != expected {
+				t.Fatalf("length %d: Rank(%d) = %d, expected %d", length, i, got, expected)
+			}
+		}
+	}
+
+	// a full set, so that superblocks hold their maximal count
+	b := New(3000).SetAll()
+	idx := b.BuildRankIndex()
+	for i := uint(0); i < 3000; i++ {
+		if idx.Rank(i) != i+1 {
+			t.Fatalf("Rank(%d) = %d, expected %d", i, idx.Rank(i), i+1)
+		}
+	}
+
+	// rebuilding after a modification
+	b.Clear(10).Clear(2000)
+	idx = b.BuildRankIndex()
+	for _, i := range []uint{9, 10, 11, 1999, 2000, 2999} {
+		if idx.Rank(i) != b.Rank(i) {
+			t.Errorf("Rank(%d) = %d, expected %d", i, idx.Rank(i), b.Rank(i))
+		}
+	}
+}
+
+func TestSelectIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(10))
+	for _, length := range []uint{0, 1, 64, 1000, 5000} {
+		for _, density := range []int{1, 3, 50} {
+			b := New(length)
+			for i := uint(0); i < length; i++ {
+				if r.Intn(density) == 0 {
+					b.Set(i)
+				}
+			}
+			for _, k := range []uint{0, 1, 2, 7, 64, 256, 100000} {
+				idx := b.BuildSelectIndexSampled(k)
+				if idx.Count() != b.Count() {
+					t.Errorf("expected count %d, got %d", b.Count(), idx.Count())
+				}
+				for j := uint(0); j < b.Count(); j++ {
+					got, ok := idx.Select(j)
+					if !ok || got != b.Select(j) {
+						t.Fatalf("length %d, k %d: Select(%d) = %d, %v; expected %d", length, k, j, got, ok, b.Select(j))
+					}
+				}
+				if _, ok := idx.Select(b.Count()); ok {
+					t.Errorf("Select(Count()) should fail")
+				}
+			}
+		}
+	}
+	b := New(100).Set(3).Set(50)
+	if got, ok := b.BuildSelectIndex().Select(1); !ok || got != 50 {
+		t.Errorf("Select(1) = %d, %v; expected 50", got, ok)
+	}
+}
@@ -0,0 +1,108 @@
+package bitset
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// ReadOnlyBitSet is a read-only view of bits stored in a slice of bytes,
+// such as a memory-mapped file, using the little-endian packed layout of
+// FromBytes and BitSet.ToBytes: bit i is bit i%8 of byte i/8. The bytes are
+// not copied, so that a ReadOnlyBitSet is cheap to create.
+type ReadOnlyBitSet struct {
+	data   []byte
+	length uint
+}
+
+// NewReadOnlyBitSet returns a ReadOnlyBitSet over data, whose length is
+// len(data)*8 bits. The slice must not be modified while it is in use.
+func NewReadOnlyBitSet(data []byte) *ReadOnlyBitSet {
+	return &ReadOnlyBitSet{data: data, length: uint(len(data)) * 8}
+}
+
+// NewReadOnlyBitSetWithLength returns a ReadOnlyBitSet over data, whose
+// length is the given number of bits: the bits of the last byte at or
+// beyond length are ignored. This is the constructor to use when the length
+// is not a multiple of 8, and is stored elsewhere. The function panics if
+// data holds fewer than length bits. The slice must not be modified while
+// it is in use.
+func NewReadOnlyBitSetWithLength(data []byte, length uint) *ReadOnlyBitSet {
+	if uint64(len(data)) < (uint64(length)+7)/8 {
+		panic("NewReadOnlyBitSetWithLength: slice is too short")
+	}
+	return &ReadOnlyBitSet{data: data[:(length+7)/8], length: length}
+}
+
+// Len returns the number of bits in the ReadOnlyBitSet.
+func (r *ReadOnlyBitSet) Len() uint {
+	return r.length
+}
+
+// Test whether bit i is set. It returns false when i >= Len().
+func (r *ReadOnlyBitSet) Test(i uint) bool {
+	if i >= r.length {
+		return false
+	}
+	return r.data[i>>3]&(1<<(i&7)) != 0
+}
+
+// Count returns the number of set bits below Len().
+func (r *ReadOnlyBitSet) Count() uint {
+	var cnt int
+	full := r.data[:r.length/8]
+	for len(full) >= wordBytes {
+		cnt += bits.OnesCount64(binary.LittleEndian.Uint64(full))
+		full = full[wordBytes:]
+	}
+	for _, v := range full {
+		cnt += bits.OnesCount8(v)
+	}
+	if rest := r.length % 8; rest != 0 {
+		// ignore the bits of the last byte beyond the length
+		cnt += bits.OnesCount8(r.data[r.length/8] & (1<<rest - 1))
+	}
+	return uint(cnt)
+}
+
+// ToBitSet returns a new BitSet with the same length and bits.
+func (r *ReadOnlyBitSet) ToBitSet() *BitSet {
+	b := FromBytes(r.data)
+	b.length = r.length
+	b.cleanLastWord()
+	return b
+}
+
+// word returns the 64-bit word x of the ReadOnlyBitSet, that is, its bits
+// 64*x to 64*x+63, with the bits at or beyond Len() cleared.
+func (r *ReadOnlyBitSet) word(x int) uint64 {
+	data := r.data[x*wordBytes:]
+	var w uint64
+	if len(data) >= wordBytes {
+		w = binary.LittleEndian.Uint64(data)
+	} else {
+		for k, v := range data {
+			w |= uint64(v) << (8 * uint(k))
+		}
+	}
+	if end := uint(x+1) << log2WordSize; end > r.length {
+		w &= allBits >> (end - r.length)
+	}
+	return w
+}
+
+// InPlaceUnionReadOnly sets the bits of b which are set in r, like
+// InPlaceUnion, reading the words of r directly from its bytes: no
+// intermediate BitSet is allocated. The length of b grows to Len() of r
+// if it is smaller.
+func (b *BitSet) InPlaceUnionReadOnly(r *ReadOnlyBitSet) *BitSet {
+	panicIfNull(b)
+	b.prepareWrite()
+	if r.length > b.length {
+		b.extendSet(r.length - 1)
+	}
+	n := wordsNeeded(r.length)
+	for x := 0; x < n; x++ {
+		b.set[x] |= r.word(x)
+	}
+	return b
+}
@@ -0,0 +1,91 @@
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestReadOnlyBitSet(t *testing.T) {
+	data := []byte{0x81, 0xff, 0x00, 0x10, 0x01, 0x02, 0x03, 0x04, 0x05, 0xf0}
+	r := NewReadOnlyBitSet(data)
+	b := FromBytes(data)
+	if r.Len() != 80 || r.Count() != b.Count() {
+		t.Errorf("unexpected length %d or count %d", r.Len(), r.Count())
+	}
+	for i := uint(0); i < 100; i++ {
+		if r.Test(i) != b.Test(i) {
+			t.Errorf("bit %d should be %v", i, b.Test(i))
+		}
+	}
+	if !r.ToBitSet().Equal(b) {
+		t.Error("ToBitSet should match FromBytes")
+	}
+}
+
+func TestNewReadOnlyBitSetWithLength(t *testing.T) {
+	data := []byte{0x81, 0xff, 0x00, 0x10, 0x01, 0x02, 0x03, 0x04, 0x05, 0xff, 0xff}
+	for _, length := range []uint{0, 1, 7, 8, 13, 63, 64, 65, 75, 80, 88} {
+		r := NewReadOnlyBitSetWithLength(data, length)
+		if r.Len() != length {
+			t.Errorf("expected length %d, got %d", length, r.Len())
+		}
+		expected := FromBytes(data)
+		var count uint
+		for i := uint(0); i < 96; i++ {
+			want := i < length && expected.Test(i)
+			if r.Test(i) != want {
+				t.Errorf("length %d: bit %d should be %v", length, i, want)
+			}
+			if want {
+				count++
+			}
+		}
+		if r.Count() != count {
+			t.Errorf("length %d: expected a count of %d, got %d", length, count, r.Count())
+		}
+		b := r.ToBitSet()
+		if b.Len() != length || b.Count() != count {
+			t.Errorf("length %d: unexpected BitSet %v of length %d", length, b, b.Len())
+		}
+		if err := b.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("a slice too short for the length should panic")
+		}
+	}()
+	NewReadOnlyBitSetWithLength(data, 89)
+}
+
+func TestInPlaceUnionReadOnly(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	for _, blen := range []uint{0, 10, 64, 100, 1000} {
+		for _, rlen := range []uint{0, 1, 7, 8, 63, 64, 65, 100, 1000} {
+			data := make([]byte, int(rlen+7)/8+r.Intn(3))
+			r.Read(data)
+			ro := NewReadOnlyBitSetWithLength(data, rlen)
+			b := New(blen)
+			for i := uint(0); i < blen; i += uint(1 + r.Intn(5)) {
+				b.Set(i)
+			}
+			expected := b.Clone()
+			expected.InPlaceUnion(ro.ToBitSet())
+			if b.InPlaceUnionReadOnly(ro); !b.Equal(expected) {
+				t.Errorf("lengths %d and %d: expected %v, got %v", blen, rlen, expected, b)
+			}
+			if err := b.CheckInvariants(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// a view over whole bytes
+	b := New(4).Set(1)
+	b.InPlaceUnionReadOnly(NewReadOnlyBitSet([]byte{0x81, 0, 0x40}))
+	if b.Len() != 24 || !b.Equal(New(24).Set(0).Set(1).Set(7).Set(22)) {
+		t.Errorf("unexpected result %v", b)
+	}
+}
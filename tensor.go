@@ -0,0 +1,73 @@
+package bitset
+
+// Index maps the coordinates of a cell of an N-dimensional array of bits,
+// whose size along each dimension is given by dims, to a bit index, using
+// the row-major layout: the last coordinate varies fastest. For example,
+// with dims {depth, height, width}, the cell {z, y, x} is the bit
+// (z*height+y)*width+x. It generalizes the layout of Grid2D to N dimensions.
+// It panics if coords and dims have different lengths, or if a coordinate
+// is out of bounds.
+func Index(coords, dims []uint) uint {
+	if len(coords) != len(dims) {
+		panic(Error("Index: coords and dims have different lengths"))
+	}
+	var index uint
+	for k, c := range coords {
+		if c >= dims[k] {
+			panic(Error("Index: coordinate out of bounds"))
+		}
+		index = index*dims[k] + c
+	}
+	return index
+}
+
+// Coords is the inverse of Index: it appends to out the coordinates of the
+// cell of bit index i in an N-dimensional array of the given dims, and
+// returns the resulting slice. It panics if i is beyond the last cell.
+func Coords(i uint, dims []uint, out []uint) []uint {
+	start := len(out)
+	for range dims {
+		out = append(out, 0)
+	}
+	coords := out[start:]
+	for k := len(dims) - 1; k >= 0; k-- {
+		if dims[k] == 0 {
+			panic(Error("Coords: index out of bounds"))
+		}
+		coords[k] = i % dims[k]
+		i /= dims[k]
+	}
+	if i != 0 {
+		panic(Error("Coords: index out of bounds"))
+	}
+	return out
+}
+
+// inBounds returns true if coords is a cell of an array of the given dims
+func inBounds(coords, dims []uint) bool {
+	if len(coords) != len(dims) {
+		return false
+	}
+	for k, c := range coords {
+		if c >= dims[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetCoords sets the bit of the cell at coords in an N-dimensional array of
+// the given dims, see Index. It panics if the cell is out of bounds.
+func (b *BitSet) SetCoords(coords, dims []uint) *BitSet {
+	return b.Set(Index(coords, dims))
+}
+
+// TestCoords returns true if the bit of the cell at coords in an
+// N-dimensional array of the given dims is set, see Index. Cells out of
+// bounds are considered clear.
+func (b *BitSet) TestCoords(coords, dims []uint) bool {
+	if !inBounds(coords, dims) {
+		return false
+	}
+	return b.Test(Index(coords, dims))
+}
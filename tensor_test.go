@@ -0,0 +1,66 @@
+package bitset
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexCoords(t *testing.T) {
+	dims := []uint{3, 4, 5} // depth, height, width
+	var i uint
+	for z := uint(0); z < 3; z++ {
+		for y := uint(0); y < 4; y++ {
+			for x := uint(0); x < 5; x++ {
+				coords := []uint{z, y, x}
+				if got := Index(coords, dims); got != i {
+					t.Errorf("Index(%v) = %d; expected %d", coords, got, i)
+				}
+				if got := Coords(i, dims, nil); !reflect.DeepEqual(got, coords) {
+					t.Errorf("Coords(%d) = %v; expected %v", i, got, coords)
+				}
+				i++
+			}
+		}
+	}
+	if got := Coords(7, []uint{2, 5}, []uint{42}); !reflect.DeepEqual(got, []uint{42, 1, 2}) {
+		t.Errorf("Coords should append to out, got %v", got)
+	}
+
+	for _, f := range []func(){
+		func() { Index([]uint{0, 0}, dims) },
+		func() { Index([]uint{3, 0, 0}, dims) },
+		func() { Index([]uint{0, 0, 5}, dims) },
+		func() { Coords(60, dims, nil) },
+		func() { Coords(0, []uint{3, 0}, nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestSetTestCoords(t *testing.T) {
+	dims := []uint{3, 4, 5}
+	b := New(60)
+	b.SetCoords([]uint{1, 2, 3}, dims).SetCoords([]uint{2, 3, 4}, dims)
+	if !b.Test(1*20+2*5+3) || !b.Test(59) || b.Count() != 2 {
+		t.Errorf("unexpected bits %v", b)
+	}
+	if !b.TestCoords([]uint{1, 2, 3}, dims) || b.TestCoords([]uint{1, 2, 4}, dims) {
+		t.Error("unexpected TestCoords result")
+	}
+	if b.TestCoords([]uint{1, 5, 3}, dims) || b.TestCoords([]uint{1, 2}, dims) {
+		t.Error("cells out of bounds should be clear")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("SetCoords should panic when out of bounds")
+		}
+	}()
+	b.SetCoords([]uint{0, 4, 0}, dims)
+}